@@ -0,0 +1,353 @@
+package alarm
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	OverTemperature  Type = "over_temperature"
+	SensorOffline    Type = "sensor_offline"
+	ArduinoOffline   Type = "arduino_offline"
+	SensorFault      Type = "sensor_fault"
+	WindowNotReached Type = "window_not_reached"
+)
+
+type Severity int
+
+const (
+	Warning Severity = iota
+	Major
+	Critical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "WARNING"
+	case Major:
+		return "MAJOR"
+	case Critical:
+		return "CRITICAL"
+	default:
+		return ""
+	}
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Severity) UnmarshalText(text []byte) error {
+	severity, err := ParseSeverity(string(text))
+	*s = severity
+	return err
+}
+
+// ParseSeverity interpreta "WARNING", "MAJOR" o "CRITICAL"; la stringa vuota vale WARNING.
+func ParseSeverity(s string) (Severity, error) {
+	if s == "" {
+		return Warning, nil
+	}
+	for _, severity := range []Severity{Warning, Major, Critical} {
+		if strings.EqualFold(s, severity.String()) {
+			return severity, nil
+		}
+	}
+	return Warning, errors.New("severità non valida: " + s)
+}
+
+type State string
+
+const (
+	Active       State = "active"
+	Acknowledged State = "acknowledged"
+	Cleared      State = "cleared"
+)
+
+// Tipo di variazione notificata da Manager.OnChange.
+type ChangeKind string
+
+const (
+	Raised           ChangeKind = "raised"
+	Escalated        ChangeKind = "escalated"
+	AcknowledgedKind ChangeKind = "acknowledged"
+	ShelvedKind      ChangeKind = "shelved"
+	ClearedKind      ChangeKind = "cleared"
+)
+
+type Change struct {
+	Kind  ChangeKind
+	Alarm Alarm
+}
+
+var (
+	ErrNotFound   = errors.New("allarme non trovato")
+	ErrNotActive  = errors.New("l'allarme non è attivo")
+	ErrNoDuration = errors.New("durata di shelving non valida")
+)
+
+// Singola occorrenza di un allarme: nasce attiva, puo' essere riconosciuta da un operatore
+// e finisce nello storico quando la condizione che l'ha generata rientra.
+type Alarm struct {
+	ID               string
+	Zone             string
+	Type             Type
+	Severity         Severity
+	State            State
+	Message          string
+	RaisedAt         time.Time
+	EscalatedAt      time.Time `json:",omitzero"`
+	AcknowledgedAt   time.Time `json:",omitzero"`
+	AcknowledgedBy   string    `json:",omitempty"`
+	Comment          string    `json:",omitempty"`
+	ShelvedUntil     time.Time `json:",omitzero"` // fino a questo istante l'allarme non viene notificato
+	ShelvedBy        string    `json:",omitempty"`
+	ClearedAt        time.Time `json:",omitzero"`
+	InitialSeverity  Severity
+	lastEscalationAt time.Time
+}
+
+func (a Alarm) Shelved(now time.Time) bool {
+	return now.Before(a.ShelvedUntil)
+}
+
+type Config struct {
+	EscalateAfter time.Duration // un allarme non riconosciuto sale di severita' dopo questo tempo; 0 disabilita
+	HistorySize   int
+	OfflineDelay  time.Duration // tempo prima di segnalare un dispositivo offline
+}
+
+type key struct {
+	zone string
+	kind Type
+}
+
+// Manager tiene gli allarmi attivi e lo storico di tutte le zone. E' condiviso tra
+// i system manager, che ne aggiornano le condizioni, e le API, che li riconoscono.
+type Manager struct {
+	config Config
+
+	mu       sync.Mutex
+	nextID   uint64
+	active   map[key]*Alarm
+	history  []Alarm
+	pending  map[key]time.Time // condizioni vere ma non ancora trascorso il ritardo
+	shelved  map[key]time.Time // shelving che sopravvive al rientro dell'allarme
+	onChange []func(Change)
+}
+
+func NewManager(config Config) *Manager {
+	if config.HistorySize <= 0 {
+		config.HistorySize = 200
+	}
+	return &Manager{
+		config:  config,
+		active:  make(map[key]*Alarm),
+		pending: make(map[key]time.Time),
+		shelved: make(map[key]time.Time),
+	}
+}
+
+// OnChange registra una funzione chiamata ad ogni cambio di stato di un allarme.
+// Viene eseguita con il lock del manager rilasciato ma in modo sincrono: non deve bloccare.
+func (m *Manager) OnChange(fn func(Change)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onChange = append(m.onChange, fn)
+}
+
+func (m *Manager) notify(changes []Change) {
+	m.mu.Lock()
+	callbacks := slices.Clone(m.onChange)
+	m.mu.Unlock()
+	for _, change := range changes {
+		for _, fn := range callbacks {
+			fn(change)
+		}
+	}
+}
+
+// set aggiorna una condizione di allarme; delay e' il tempo per cui la condizione
+// deve restare vera prima che l'allarme venga sollevato. Va chiamata con il lock.
+func (m *Manager) set(k key, active bool, severity Severity, message string, delay time.Duration, now time.Time) (Change, bool) {
+	current, raised := m.active[k]
+	if !active {
+		delete(m.pending, k)
+		if !raised {
+			return Change{}, false
+		}
+		delete(m.active, k)
+		current.State = Cleared
+		current.ClearedAt = now
+		m.history = append(m.history, *current)
+		if len(m.history) > m.config.HistorySize {
+			m.history = slices.Delete(m.history, 0, len(m.history)-m.config.HistorySize)
+		}
+		return Change{ClearedKind, *current}, true
+	}
+	if raised {
+		return m.escalate(current, now)
+	}
+
+	since, ok := m.pending[k]
+	if !ok {
+		since = now
+		m.pending[k] = now
+	}
+	if now.Sub(since) < delay {
+		return Change{}, false
+	}
+	delete(m.pending, k)
+
+	m.nextID++
+	alarm := &Alarm{
+		ID:               strconv.FormatUint(m.nextID, 10),
+		Zone:             k.zone,
+		Type:             k.kind,
+		Severity:         severity,
+		InitialSeverity:  severity,
+		State:            Active,
+		Message:          message,
+		RaisedAt:         now,
+		lastEscalationAt: now,
+	}
+	if until, ok := m.shelved[k]; ok && now.Before(until) {
+		alarm.ShelvedUntil = until
+	}
+	m.active[k] = alarm
+	return Change{Raised, *alarm}, true
+}
+
+// escalate alza la severita' degli allarmi non riconosciuti e non accantonati.
+func (m *Manager) escalate(alarm *Alarm, now time.Time) (Change, bool) {
+	if m.config.EscalateAfter <= 0 || alarm.State != Active || alarm.Severity == Critical || alarm.Shelved(now) {
+		return Change{}, false
+	}
+	if now.Sub(alarm.lastEscalationAt) < m.config.EscalateAfter {
+		return Change{}, false
+	}
+	alarm.Severity++
+	alarm.EscalatedAt = now
+	alarm.lastEscalationAt = now
+	return Change{Escalated, *alarm}, true
+}
+
+func (m *Manager) find(id string) *Alarm {
+	for _, alarm := range m.active {
+		if alarm.ID == id {
+			return alarm
+		}
+	}
+	return nil
+}
+
+// Acknowledge registra la presa in carico dell'allarme da parte di un operatore.
+func (m *Manager) Acknowledge(id, user, comment string) (Alarm, error) {
+	m.mu.Lock()
+	alarm := m.find(id)
+	if alarm == nil {
+		m.mu.Unlock()
+		return Alarm{}, ErrNotFound
+	}
+	if alarm.State != Active {
+		m.mu.Unlock()
+		return *alarm, ErrNotActive
+	}
+	alarm.State = Acknowledged
+	alarm.AcknowledgedAt = time.Now()
+	alarm.AcknowledgedBy = user
+	alarm.Comment = comment
+	changed := *alarm
+	m.mu.Unlock()
+
+	m.notify([]Change{{AcknowledgedKind, changed}})
+	return changed, nil
+}
+
+// Shelve sospende le notifiche dell'allarme per la durata indicata, anche se nel frattempo
+// rientra e si ripresenta; con duration 0 lo shelving viene annullato.
+func (m *Manager) Shelve(id, user, comment string, duration time.Duration) (Alarm, error) {
+	if duration < 0 {
+		return Alarm{}, ErrNoDuration
+	}
+	m.mu.Lock()
+	alarm := m.find(id)
+	if alarm == nil {
+		m.mu.Unlock()
+		return Alarm{}, ErrNotFound
+	}
+	k := key{alarm.Zone, alarm.Type}
+	if duration == 0 {
+		alarm.ShelvedUntil = time.Time{}
+		alarm.ShelvedBy = ""
+		delete(m.shelved, k)
+	} else {
+		alarm.ShelvedUntil = time.Now().Add(duration)
+		alarm.ShelvedBy = user
+		m.shelved[k] = alarm.ShelvedUntil
+	}
+	if comment != "" {
+		alarm.Comment = comment
+	}
+	changed := *alarm
+	m.mu.Unlock()
+
+	m.notify([]Change{{ShelvedKind, changed}})
+	return changed, nil
+}
+
+// Restore reinserisce tra gli allarmi attivi quelli salvati prima di un riavvio, senza
+// notificarli di nuovo; riconoscimento e shelving vengono mantenuti. Un allarme gia'
+// attivo per la stessa zona e tipo non viene sostituito.
+func (m *Manager) Restore(alarms []Alarm) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, alarm := range alarms {
+		k := key{alarm.Zone, alarm.Type}
+		if _, ok := m.active[k]; ok || alarm.State == Cleared {
+			continue
+		}
+		alarm.lastEscalationAt = alarm.RaisedAt
+		if !alarm.EscalatedAt.IsZero() {
+			alarm.lastEscalationAt = alarm.EscalatedAt
+		}
+		if id, err := strconv.ParseUint(alarm.ID, 10, 64); err == nil && id > m.nextID {
+			m.nextID = id
+		}
+		m.active[k] = &alarm
+	}
+}
+
+// Active restituisce gli allarmi attivi o riconosciuti, dal piu' recente; zone vuota indica tutte le zone.
+func (m *Manager) Active(zone string) []Alarm {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	alarms := make([]Alarm, 0, len(m.active))
+	for _, alarm := range m.active {
+		if zone == "" || alarm.Zone == zone {
+			alarms = append(alarms, *alarm)
+		}
+	}
+	slices.SortFunc(alarms, func(a, b Alarm) int { return b.RaisedAt.Compare(a.RaisedAt) })
+	return alarms
+}
+
+// History restituisce gli allarmi rientrati, dal piu' recente.
+func (m *Manager) History(zone string) []Alarm {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	alarms := make([]Alarm, 0, len(m.history))
+	for i := len(m.history) - 1; i >= 0; i-- {
+		if zone == "" || m.history[i].Zone == zone {
+			alarms = append(alarms, m.history[i])
+		}
+	}
+	return alarms
+}
@@ -0,0 +1,196 @@
+package alarm_test
+
+import (
+	"errors"
+	"server/alarm"
+	"server/system"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+
+// stato di una zona senza condizioni di allarme
+func healthy() system.SystemState {
+	return system.SystemState{
+		Zone:          "serra",
+		Status:        system.Normal,
+		DevicesOnline: map[system.DeviceName]bool{"esp32": true, "arduino": true},
+	}
+}
+
+func overheated() system.SystemState {
+	state := healthy()
+	state.Status = system.Alarm
+	return state
+}
+
+func recorder(m *alarm.Manager) *[]alarm.Change {
+	var changes []alarm.Change
+	m.OnChange(func(c alarm.Change) { changes = append(changes, c) })
+	return &changes
+}
+
+func kinds(changes []alarm.Change) []alarm.ChangeKind {
+	var k []alarm.ChangeKind
+	for _, c := range changes {
+		k = append(k, c.Kind)
+	}
+	return k
+}
+
+func TestRaiseAndClear(t *testing.T) {
+	m := alarm.NewManager(alarm.Config{})
+	changes := recorder(m)
+
+	m.Evaluate(overheated(), start)
+	m.Evaluate(overheated(), start.Add(time.Second))
+	active := m.Active("serra")
+	if len(active) != 1 || active[0].Type != alarm.OverTemperature || active[0].Severity != alarm.Critical || active[0].State != alarm.Active {
+		t.Fatalf("allarmi attivi %+v", active)
+	}
+	if len(m.Active("ufficio")) != 0 {
+		t.Fatal("allarme attribuito alla zona sbagliata")
+	}
+
+	m.Evaluate(healthy(), start.Add(time.Minute))
+	if len(m.Active("")) != 0 {
+		t.Fatal("allarme ancora attivo dopo il rientro della condizione")
+	}
+	history := m.History("serra")
+	if len(history) != 1 || history[0].State != alarm.Cleared || !history[0].ClearedAt.Equal(start.Add(time.Minute)) {
+		t.Fatalf("storico %+v", history)
+	}
+	if got := kinds(*changes); len(got) != 2 || got[0] != alarm.Raised || got[1] != alarm.ClearedKind {
+		t.Fatalf("notifiche %v", got)
+	}
+}
+
+func TestOfflineAlarmWaitsForDelay(t *testing.T) {
+	m := alarm.NewManager(alarm.Config{OfflineDelay: 30 * time.Second})
+	offline := healthy()
+	offline.DevicesOnline["arduino"] = false
+
+	m.Evaluate(offline, start)
+	m.Evaluate(offline, start.Add(29*time.Second))
+	if len(m.Active("serra")) != 0 {
+		t.Fatal("allarme offline sollevato prima del ritardo")
+	}
+	// un ritorno online azzera l'attesa
+	m.Evaluate(healthy(), start.Add(30*time.Second))
+	m.Evaluate(offline, start.Add(40*time.Second))
+	m.Evaluate(offline, start.Add(60*time.Second))
+	if len(m.Active("serra")) != 0 {
+		t.Fatal("attesa non ripartita dopo il ritorno online")
+	}
+	m.Evaluate(offline, start.Add(70*time.Second))
+	if active := m.Active("serra"); len(active) != 1 || active[0].Type != alarm.ArduinoOffline || active[0].Severity != alarm.Major {
+		t.Fatalf("allarmi attivi %+v", active)
+	}
+}
+
+func TestEscalationStopsWhenAcknowledged(t *testing.T) {
+	m := alarm.NewManager(alarm.Config{EscalateAfter: 10 * time.Minute})
+	changes := recorder(m)
+	fault := healthy()
+	fault.SensorFault = system.FaultStuck
+
+	m.Evaluate(fault, start)
+	m.Evaluate(fault, start.Add(9*time.Minute))
+	if a := m.Active("serra")[0]; a.Severity != alarm.Major {
+		t.Fatalf("severita' %v prima di EscalateAfter", a.Severity)
+	}
+	m.Evaluate(fault, start.Add(10*time.Minute))
+	a := m.Active("serra")[0]
+	if a.Severity != alarm.Critical || a.InitialSeverity != alarm.Major || !a.EscalatedAt.Equal(start.Add(10*time.Minute)) {
+		t.Fatalf("allarme non scalato: %+v", a)
+	}
+
+	m2 := alarm.NewManager(alarm.Config{EscalateAfter: 10 * time.Minute})
+	m2.Evaluate(fault, start)
+	id := m2.Active("serra")[0].ID
+	acked, err := m2.Acknowledge(id, "mario", "sensore da sostituire")
+	if err != nil || acked.State != alarm.Acknowledged || acked.AcknowledgedBy != "mario" {
+		t.Fatalf("riconoscimento: %+v %v", acked, err)
+	}
+	if _, err := m2.Acknowledge(id, "mario", ""); !errors.Is(err, alarm.ErrNotActive) {
+		t.Fatalf("secondo riconoscimento: %v", err)
+	}
+	if _, err := m2.Acknowledge("999", "mario", ""); !errors.Is(err, alarm.ErrNotFound) {
+		t.Fatalf("riconoscimento di un allarme inesistente: %v", err)
+	}
+	m2.Evaluate(fault, start.Add(time.Hour))
+	if a := m2.Active("serra")[0]; a.Severity != alarm.Major {
+		t.Fatalf("allarme riconosciuto scalato a %v", a.Severity)
+	}
+
+	if got := kinds(*changes); len(got) != 2 || got[1] != alarm.Escalated {
+		t.Fatalf("notifiche %v", got)
+	}
+}
+
+func TestShelvingSurvivesClearAndStopsEscalation(t *testing.T) {
+	m := alarm.NewManager(alarm.Config{EscalateAfter: time.Minute})
+	fault := healthy()
+	fault.SensorFault = system.FaultStuck
+
+	// Shelve usa l'ora reale: gli istanti della valutazione restano entro lo shelving
+	now := time.Now()
+	m.Evaluate(fault, now)
+	id := m.Active("serra")[0].ID
+	if _, err := m.Shelve(id, "mario", "", -time.Minute); !errors.Is(err, alarm.ErrNoDuration) {
+		t.Fatalf("shelving con durata negativa: %v", err)
+	}
+	shelved, err := m.Shelve(id, "mario", "manutenzione", time.Hour)
+	if err != nil || !shelved.Shelved(now) || shelved.ShelvedBy != "mario" || shelved.Comment != "manutenzione" {
+		t.Fatalf("shelving: %+v %v", shelved, err)
+	}
+	m.Evaluate(fault, now.Add(10*time.Minute))
+	if a := m.Active("serra")[0]; a.Severity != alarm.Major {
+		t.Fatalf("allarme accantonato scalato a %v", a.Severity)
+	}
+
+	// l'allarme rientra e si ripresenta: resta accantonato
+	m.Evaluate(healthy(), now.Add(11*time.Minute))
+	m.Evaluate(fault, now.Add(12*time.Minute))
+	again := m.Active("serra")[0]
+	if again.ID == id || !again.Shelved(now.Add(12*time.Minute)) {
+		t.Fatalf("shelving perso al ripresentarsi dell'allarme: %+v", again)
+	}
+
+	// durata 0 annulla lo shelving
+	if a, err := m.Shelve(again.ID, "mario", "", 0); err != nil || a.Shelved(now) {
+		t.Fatalf("annullamento dello shelving: %+v %v", a, err)
+	}
+	m.Evaluate(healthy(), now.Add(13*time.Minute))
+	m.Evaluate(fault, now.Add(14*time.Minute))
+	if m.Active("serra")[0].Shelved(now.Add(14 * time.Minute)) {
+		t.Fatal("shelving ancora attivo dopo l'annullamento")
+	}
+}
+
+func TestRestoreKeepsAcknowledgementWithoutNotifying(t *testing.T) {
+	m := alarm.NewManager(alarm.Config{EscalateAfter: time.Minute})
+	changes := recorder(m)
+	m.Restore([]alarm.Alarm{
+		{ID: "7", Zone: "serra", Type: alarm.OverTemperature, Severity: alarm.Critical, State: alarm.Acknowledged, RaisedAt: start},
+		{ID: "8", Zone: "serra", Type: alarm.SensorFault, State: alarm.Cleared, RaisedAt: start},
+	})
+	active := m.Active("serra")
+	if len(active) != 1 || active[0].ID != "7" || active[0].State != alarm.Acknowledged {
+		t.Fatalf("allarmi ripristinati %+v", active)
+	}
+	if len(*changes) != 0 {
+		t.Fatalf("notifiche per gli allarmi ripristinati: %v", kinds(*changes))
+	}
+
+	// i nuovi allarmi proseguono la numerazione di quelli ripristinati
+	fault := overheated()
+	fault.SensorFault = system.FaultStuck
+	m.Evaluate(fault, start.Add(time.Second))
+	for _, a := range m.Active("serra") {
+		if a.Type == alarm.SensorFault && a.ID != "8" {
+			t.Fatalf("nuovo allarme con ID %s invece di 8", a.ID)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package alarm
+
+import (
+	"fmt"
+	"server/system"
+	"time"
+)
+
+// Evaluate aggiorna gli allarmi della zona a partire dallo stato del system manager.
+// Va chiamata ad ogni iterazione del ciclo della zona.
+func (m *Manager) Evaluate(state system.SystemState, now time.Time) {
+	m.mu.Lock()
+	var changes []Change
+	set := func(kind Type, active bool, severity Severity, message string, delay time.Duration) {
+		if change, changed := m.set(key{state.Zone, kind}, active, severity, message, delay, now); changed {
+			changes = append(changes, change)
+		}
+	}
+
+	set(OverTemperature, state.Status == system.Alarm, Critical,
+		fmt.Sprintf("Temperatura troppo alta per troppo tempo (%.1f°C)", state.CurrentTemp), 0)
+	set(SensorOffline, !state.DevicesOnline["esp32"], Major,
+		"Nessun sensore di temperatura online", m.config.OfflineDelay)
+	set(ArduinoOffline, !state.DevicesOnline["arduino"], Major,
+		"Arduino non raggiungibile", m.config.OfflineDelay)
+	set(SensorFault, state.SensorFault != "", Major,
+		"Sensore in fault: "+state.SensorFault, 0)
+
+	set(WindowNotReached, state.Actuator.Status == system.ActuatorFault, Major,
+		fmt.Sprintf("La finestra non raggiunge la posizione comandata (%d° invece di %d°)", state.WindowPosition, state.Actuator.Target), 0)
+	m.mu.Unlock()
+
+	m.notify(changes)
+}
@@ -3,15 +3,39 @@ package arduinoserial
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"server/bus"
+	"server/logging"
+	"server/metrics"
 	"server/system"
 	"time"
 
 	"go.bug.st/serial"
 )
 
+var logger = logging.For("arduinoserial")
+
+var (
+	framesCounter = metrics.NewCounterVec("control_unit_serial_frames_total",
+		"Pacchetti ricevuti correttamente da Arduino.", "zone")
+	frameErrorsCounter = metrics.NewCounterVec("control_unit_serial_frame_errors_total",
+		"Pacchetti da Arduino illeggibili o non validi.", "zone")
+	resyncsCounter = metrics.NewCounterVec("control_unit_serial_resyncs_total",
+		"Risincronizzazioni sull'intestazione dei pacchetti.", "zone")
+)
+
+// attese tra i tentativi di connessione quando Arduino non viene trovato
+const (
+	connectRetryMin = 1 * time.Second
+	connectRetryMax = 30 * time.Second
+)
+
+// errori di lettura consecutivi, esclusa la perdita di allineamento, dopo cui la porta
+// viene considerata persa
+const maxReadErrors = 10
+
 // --- Tipi per la comunicazione con Arduino ---
 
 type DataFromArduino struct {
@@ -38,29 +62,45 @@ type Arduino struct {
 	Events    []Message
 }
 
-func ManageArduino(ctx context.Context, dataFromArduino chan DataFromArduino, dataToArduino <-chan DataToArduino) {
+// ManageArduino gestisce la comunicazione con l'Arduino di una zona.
+// Se portName e' vuoto la porta viene cercata tra tutte quelle disponibili.
+// Restituisce un errore dopo maxReadErrors letture fallite consecutive, per esempio se la
+// porta e' stata scollegata: il supervisor la riapre riavviando il servizio.
+func ManageArduino(ctx context.Context, zone, portName string, dataFromArduino *bus.Topic[DataFromArduino], dataToArduino <-chan DataToArduino) error {
+	logger := logger.With("zone", zone)
 	var arduino *Arduino
 	var err error
+	retryDelay := connectRetryMin
 	for {
-		arduino, err = createArduino(ctx, 9600, 2*time.Second)
-		if err != nil {
-			if ctx.Err() != nil {
-				log.Println("Arduino Manager: Shutdown, chiusura richiesta durante la ricerca della porta")
-				return
-			}
-			log.Println("errore %w, riprovo ricerca", err)
-		} else {
+		arduino, err = createArduino(ctx, portName, 9600, 2*time.Second)
+		if err == nil {
 			defer arduino.Disconnect()
 			break
 		}
+		if ctx.Err() != nil {
+			logger.Info("shutdown during port search")
+			return nil
+		}
+		logger.Warn("arduino connection failed, retrying", "port", portName, "error", err, "retry_in", retryDelay)
+		select {
+		case <-ctx.Done():
+			logger.Info("shutdown during port search")
+			return nil
+		case <-time.After(retryDelay):
+		}
+		retryDelay = min(retryDelay*2, connectRetryMax)
 	}
+	logger = logger.With("port", arduino.portName)
+	// ferma anche lo scrittore quando il lettore rinuncia alla porta
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	go func() {
 		byteToSend := make([]byte, 2)
 		for {
 			select {
 			case <-ctx.Done():
-				log.Println("Arduino Manager: scrittura fermata dal context")
+				logger.Info("writer stopped")
 				return
 			case cmd := <-dataToArduino:
 				binary.LittleEndian.PutUint16(byteToSend, uint16(cmd.Temperature))
@@ -74,7 +114,7 @@ func ManageArduino(ctx context.Context, dataFromArduino chan DataFromArduino, da
 				binary.LittleEndian.PutUint16(byteToSend, uint16(cmd.SystemWindowPosition))
 				arduino.AddDataToSend(4, byteToSend)
 				if err := arduino.WriteData(); err != nil {
-					log.Printf("ERRORE: Impossibile inviare dati ad Arduino: %v", err)
+					logger.Error("write to arduino failed", "error", err)
 				}
 			}
 		}
@@ -82,29 +122,45 @@ func ManageArduino(ctx context.Context, dataFromArduino chan DataFromArduino, da
 
 	// Loop principale per la lettura continua da Arduino
 	wasButtonPressed := false
+	readErrors := 0
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Arduino Manager: lettura fermata dal context")
-			return
+			logger.Info("reader stopped")
+			return nil
 		default:
 			vars, _, _, err := arduino.ReadData()
+			if errors.Is(err, errSync) {
+				resyncsCounter.With(zone).Inc()
+			}
 			if err != nil {
-				log.Println(err)
+				frameErrorsCounter.With(zone).Inc()
+				// la perdita di allineamento si risolve leggendo i pacchetti successivi
+				if !errors.Is(err, errSync) {
+					readErrors++
+				}
+				if readErrors >= maxReadErrors {
+					return fmt.Errorf("%d letture consecutive fallite da Arduino: %w", readErrors, err)
+				}
+				logger.Warn("read from arduino failed", "error", err, "consecutive", readErrors)
 				continue
 			}
+			readErrors = 0
 
 			if len(vars) < 2 {
-				log.Println("WARN: Ricevuto pacchetto incompleto da Arduino.")
+				frameErrorsCounter.With(zone).Inc()
+				logger.Warn("incomplete packet from arduino", "variables", len(vars))
 				continue
 			}
 
 			buttonValue, ok1 := vars[0].Data.(int16)
 			windowPos, ok2 := vars[1].Data.(int16)
 			if !ok1 || !ok2 {
-				log.Println("ERRORE: Dati da Arduino non validi o tipo inatteso.")
+				frameErrorsCounter.With(zone).Inc()
+				logger.Error("invalid data from arduino", "button", vars[0].Data, "window", vars[1].Data)
 				continue
 			}
+			framesCounter.With(zone).Inc()
 
 			buttonPressed := buttonValue == 1
 			buttonTrig := false
@@ -115,39 +171,31 @@ func ManageArduino(ctx context.Context, dataFromArduino chan DataFromArduino, da
 
 			newData := DataFromArduino{WindowPosition: system.Degree(windowPos), ButtonPressed: buttonTrig}
 
-			select {
-			case dataFromArduino <- newData:
-			default:
-				<-dataFromArduino
-				dataFromArduino <- newData
-				log.Println("WARN: Buffer dati da Arduino pieno. Scartato il valore più vecchio per inserire il più recente.")
-			}
+			dataFromArduino.Publish(newData)
 		}
 	}
-
 }
 
-func createArduino(ctx context.Context, baudRate int, readTimeout time.Duration) (*Arduino, error) {
+func createArduino(ctx context.Context, portName string, baudRate int, readTimeout time.Duration) (*Arduino, error) {
 
 	for {
 		select {
 		case <-ctx.Done():
 			return nil, fmt.Errorf("Arduino Manager stopped meanwhile searching for arduino port")
 		default:
-			log.Println("Searching for arduino port")
-			arduinoConn, portName, err := findArduinoPort(baudRate, readTimeout)
+			logger.Debug("searching for arduino port", "port", portName)
+			arduinoConn, portName, err := findArduinoPort(portName, baudRate, readTimeout)
 			if err != nil {
 				return nil, err
 			}
 			if arduinoConn != nil {
-				log.Println("Found arduino port: " + portName)
 				arduino := &Arduino{
 					portName: portName,
 					baudrate: baudRate,
 					timeout:  readTimeout,
 					protocol: NewProtocol(arduinoConn),
 				}
-				log.Println("INFO: Connesso ad Arduino.")
+				logger.Info("connected to arduino", "port", portName)
 				return arduino, nil
 			}
 			time.Sleep(1 * time.Second)
@@ -156,10 +204,14 @@ func createArduino(ctx context.Context, baudRate int, readTimeout time.Duration)
 	}
 }
 
-func findArduinoPort(baudRate int, readTimeout time.Duration) (io.ReadWriteCloser, string, error) {
-	ports, err := getSerialPorts()
-	if err != nil {
-		return nil, "", fmt.Errorf("errore nella ricerca delle porte: %w", err)
+func findArduinoPort(portName string, baudRate int, readTimeout time.Duration) (io.ReadWriteCloser, string, error) {
+	ports := []string{portName}
+	if portName == "" {
+		found, err := getSerialPorts()
+		if err != nil {
+			return nil, "", fmt.Errorf("errore nella ricerca delle porte: %w", err)
+		}
+		ports = found
 	}
 
 	for _, port := range ports {
@@ -168,7 +220,7 @@ func findArduinoPort(baudRate int, readTimeout time.Duration) (io.ReadWriteClose
 			return conn, port, nil
 		}
 	}
-	return nil, "", err
+	return nil, "", nil
 
 }
 
@@ -186,7 +238,7 @@ func getSerialPorts() ([]string, error) {
 func (ar *Arduino) Disconnect() {
 	if ar.protocol != nil {
 		ar.protocol.conn.Close()
-		fmt.Println("Connessione chiusa.")
+		logger.Info("connection closed", "port", ar.portName)
 		ar.protocol = nil
 	}
 }
@@ -229,7 +281,7 @@ func (ar *Arduino) ReadData() (vars []Message, debugs []Message, events []Messag
 
 func (ar *Arduino) AddDataToSend(id byte, value []byte) {
 	if ar.protocol == nil {
-		fmt.Println("Protocollo non inizializzato, impossibile aggiungere dati.")
+		logger.Error("protocol not initialized, data not queued", "id", id)
 		return
 	}
 	ar.protocol.AddVariableToSend(id, value)
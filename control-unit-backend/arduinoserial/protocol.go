@@ -2,6 +2,7 @@ package arduinoserial
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -10,6 +11,9 @@ import (
 	"github.com/tarm/serial"
 )
 
+// Intestazione del pacchetto non valida: la lettura riprende dai byte successivi.
+var errSync = errors.New("errore di sincronizzazione")
+
 type MessageType byte
 
 const (
@@ -65,7 +69,7 @@ func Handshake(portName string, baudrate int, readTimeout time.Duration) (io.Rea
 	buf := make([]byte, 1)
 	cycleCount := 0
 	for {
-		fmt.Println("Prova Handshake su: " + portName)
+		logger.Debug("handshake attempt", "port", portName)
 
 		// Invia handshake (byte 255)
 		if _, err := conn.Write([]byte{255}); err != nil {
@@ -113,7 +117,7 @@ func (p *Protocol) ReadCommunicationData() (int, error) {
 	}
 
 	if b1 != 255 || b2 != 0 {
-		return 0, fmt.Errorf("errore di sincronizzazione, ricevuto: %d, %d", b1, b2)
+		return 0, fmt.Errorf("%w, ricevuto: %d, %d", errSync, b1, b2)
 	}
 
 	numMessages, err := p.readByte()
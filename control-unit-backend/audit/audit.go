@@ -0,0 +1,347 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"server/logging"
+	"server/system"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var logger = logging.For("audit")
+
+const (
+	// voci in attesa di scrittura inviate con AppendAsync
+	asyncQueueSize = 256
+	// attesa massima di AppendAsync con la coda piena prima di rinunciare alla voce
+	asyncQueueTimeout = time.Second
+)
+
+// Riassunto dello stato di una zona prima e dopo un comando.
+type State struct {
+	Status                string
+	OperativeMode         string
+	CurrentTemp           float64
+	WindowPosition        system.Degree
+	CommandWindowPosition system.Degree
+}
+
+func StateOf(s system.SystemState) *State {
+	return &State{
+		Status:                s.Status.String(),
+		OperativeMode:         s.OperativeMode.String(),
+		CurrentTemp:           s.CurrentTemp,
+		WindowPosition:        s.WindowPosition,
+		CommandWindowPosition: s.CommandWindowPosition,
+	}
+}
+
+// Voce dell'audit log: un comando verso una zona o un'azione di un operatore sulle API.
+type Record struct {
+	Time   time.Time
+	Zone   string         `json:",omitempty"`
+	Source string         // http, mqtt, arduino-button, scheduler, system
+	User   string         `json:",omitempty"`
+	Action string         // nome del comando o dell'azione
+	Params map[string]any `json:",omitempty"`
+	Result string         // "ok" o il motivo del rifiuto
+	Before *State         `json:",omitempty"`
+	After  *State         `json:",omitempty"`
+}
+
+// Log scrive le voci in JSON, una per riga, in un file aperto in sola aggiunta.
+// Quando il file supera MaxSize viene ruotato in path.1, path.2, ... fino a MaxFiles.
+// Un Log nil non registra nulla.
+type Log struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	queueMu sync.RWMutex // protegge l'invio su queue dalla sua chiusura in Close
+	closed  bool
+	queue   chan Record
+	done    chan struct{}
+	lost    atomic.Uint64 // voci perse dall'ultima scritta, registrate alla prima scrittura utile
+}
+
+func Open(path string, maxSize int64, maxFiles int) (*Log, error) {
+	l := &Log{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		queue:    make(chan Record, asyncQueueSize),
+		done:     make(chan struct{}),
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("errore apertura audit log: %w", err)
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	go l.writeQueued()
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("errore apertura audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("errore apertura audit log: %w", err)
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+func (l *Log) rotatedPath(n int) string {
+	return l.path + "." + strconv.Itoa(n)
+}
+
+// rotate va chiamata con il lock.
+func (l *Log) rotate() error {
+	l.file.Close()
+	os.Remove(l.rotatedPath(l.maxFiles))
+	for n := l.maxFiles - 1; n >= 1; n-- {
+		os.Rename(l.rotatedPath(n), l.rotatedPath(n+1))
+	}
+	if err := os.Rename(l.path, l.rotatedPath(1)); err != nil {
+		logger.Error("audit log rotation failed", "path", l.path, "error", err)
+	}
+	return l.open()
+}
+
+// Append aggiunge una voce e la rende persistente prima di restituire.
+func (l *Log) Append(record Record) {
+	if l == nil {
+		return
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		logger.Error("audit record serialization failed", "action", record.Action, "error", err)
+		return
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size+int64(len(data)) > l.maxSize && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			logger.Error("audit log reopen failed", "path", l.path, "error", err)
+			return
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		logger.Error("audit log write failed", "path", l.path, "error", err)
+	}
+}
+
+// AppendAsync accoda una voce senza attendere la scrittura su disco, per chi non puo'
+// bloccarsi sull'I/O come il system manager. Con la coda piena attende al massimo
+// asyncQueueTimeout; se la scrittura resta bloccata oltre, la voce viene persa, contata e
+// segnalata, e nel log resta una voce "audit-records-lost" con il numero di voci mancanti.
+func (l *Log) AppendAsync(record Record) {
+	if l == nil {
+		return
+	}
+	l.queueMu.RLock()
+	defer l.queueMu.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.queue <- record:
+		return
+	default:
+	}
+	timer := time.NewTimer(asyncQueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.queue <- record:
+	case <-timer.C:
+		l.lost.Add(1)
+		system.DroppedEntries.With(record.Zone, "audit").Inc()
+		logger.Error("audit log stalled, record lost", "buffer", "audit", "action", record.Action,
+			"zone", record.Zone, "waited", asyncQueueTimeout)
+	}
+}
+
+func (l *Log) writeQueued() {
+	defer close(l.done)
+	for record := range l.queue {
+		if lost := l.lost.Swap(0); lost > 0 {
+			l.Append(Record{
+				Time:   time.Now(),
+				Source: "system",
+				Action: "audit-records-lost",
+				Params: map[string]any{"count": lost},
+				Result: "coda di scrittura piena",
+			})
+		}
+		l.Append(record)
+	}
+}
+
+// Command registra, senza bloccare, l'esecuzione di un comando da parte del system manager di una zona.
+func (l *Log) Command(request system.CommandRequest, before, after system.SystemState, err error, now time.Time) {
+	if l == nil {
+		return
+	}
+	params := map[string]any{}
+	switch request.Type {
+	case system.SetWindowPosition:
+		params["position"] = request.Position
+	case system.SetMode:
+		params["mode"] = request.Mode.String()
+	}
+	if request.ID != "" {
+		params["id"] = request.ID
+	}
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	l.AppendAsync(Record{
+		Time:   now,
+		Zone:   after.Zone,
+		Source: string(request.Source),
+		User:   request.User,
+		Action: request.Type.String(),
+		Params: params,
+		Result: result,
+		Before: StateOf(before),
+		After:  StateOf(after),
+	})
+}
+
+// Close scrive le voci ancora in coda e chiude il file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.queueMu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.queue)
+	}
+	l.queueMu.Unlock()
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Filtri di ricerca; i campi vuoti non filtrano.
+type Query struct {
+	Zone   string
+	Source string
+	User   string
+	Action string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+func (q Query) match(r Record) bool {
+	return (q.Zone == "" || r.Zone == q.Zone) &&
+		(q.Source == "" || r.Source == q.Source) &&
+		(q.User == "" || r.User == q.User) &&
+		(q.Action == "" || r.Action == q.Action) &&
+		(q.From.IsZero() || !r.Time.Before(q.From)) &&
+		(q.To.IsZero() || r.Time.Before(q.To))
+}
+
+// snapshot apre, con il lock, il file corrente e quelli ruotati: i file aperti restano leggibili
+// anche se una rotazione li rinomina durante la ricerca. Del file corrente viene letto solo
+// quanto scritto finora, cosi' da non leggere una voce a meta'.
+func (l *Log) snapshot() ([]io.ReadCloser, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var files []io.ReadCloser
+	paths := []string{l.path}
+	for n := 1; n <= l.maxFiles; n++ {
+		paths = append(paths, l.rotatedPath(n))
+	}
+	for i, path := range paths {
+		file, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		if i == 0 {
+			files = append(files, struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(file, l.size), file})
+			continue
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// Search legge il file corrente e quelli ruotati e restituisce le voci corrispondenti,
+// dalla piu' recente, al massimo Limit. La lettura avviene senza lock, quindi non ferma
+// le scritture.
+func (l *Log) Search(q Query) ([]Record, error) {
+	if l == nil {
+		return nil, nil
+	}
+	files, err := l.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	var records []Record
+	for _, file := range files {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var r Record
+			if json.Unmarshal(scanner.Bytes(), &r) == nil && q.match(r) {
+				records = append(records, r)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	slices.SortStableFunc(records, func(a, b Record) int { return b.Time.Compare(a.Time) })
+	if q.Limit > 0 && len(records) > q.Limit {
+		records = records[:q.Limit]
+	}
+	return records, nil
+}
@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"fmt"
+	"path/filepath"
+	"server/system"
+	"sync"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+
+func openLog(t *testing.T, maxSize int64, maxFiles int) (*Log, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, maxSize, maxFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, path
+}
+
+func TestCommandIsWrittenAsynchronouslyAndFlushedOnClose(t *testing.T) {
+	l, path := openLog(t, 0, 0)
+	request := system.NewCommandRequest("42", system.SetWindowPosition, system.SourceMQTT)
+	request.Position = 30
+	for i := range 10 {
+		l.Command(request, system.SystemState{Zone: "serra"}, system.SystemState{Zone: "serra"}, nil, start.Add(time.Duration(i)*time.Second))
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// dopo Close le voci accodate sono su disco e le nuove vengono ignorate
+	l.Command(request, system.SystemState{}, system.SystemState{}, nil, start)
+
+	reopened, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	records, err := reopened.Search(Query{Zone: "serra"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 10 {
+		t.Fatalf("%d voci invece di 10", len(records))
+	}
+	if r := records[0]; r.Action != "SetWindowPosition" || r.Source != string(system.SourceMQTT) || r.Params["id"] != "42" || !r.Time.Equal(start.Add(9*time.Second)) {
+		t.Fatalf("voce piu' recente %+v", r)
+	}
+}
+
+func TestSearchCoversRotatedFiles(t *testing.T) {
+	l, _ := openLog(t, 400, 3)
+	defer l.Close()
+	for i := range 12 {
+		l.Append(Record{Time: start.Add(time.Duration(i) * time.Minute), Zone: "serra", Source: "http", Action: fmt.Sprintf("azione-%d", i), Result: "ok"})
+	}
+	records, err := l.Search(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) < 5 || records[0].Action != "azione-11" {
+		t.Fatalf("voci trovate %d, la piu' recente %+v", len(records), records[0])
+	}
+	limited, _ := l.Search(Query{Limit: 2, From: start.Add(5 * time.Minute)})
+	if len(limited) != 2 || limited[1].Action != "azione-10" {
+		t.Fatalf("ricerca limitata %+v", limited)
+	}
+}
+
+func TestSearchDoesNotBlockWrites(t *testing.T) {
+	l, _ := openLog(t, 4096, 2)
+	defer l.Close()
+	for i := range 200 {
+		l.Append(Record{Time: start.Add(time.Duration(i) * time.Second), Source: "http", Action: "prima", Result: "ok"})
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range 200 {
+			l.Append(Record{Time: start.Add(time.Hour + time.Duration(i)*time.Second), Source: "http", Action: "durante", Result: "ok"})
+		}
+	}()
+	// le ricerche concorrenti con scritture e rotazioni leggono solo voci complete
+	for range 20 {
+		records, err := l.Search(Query{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range records {
+			if r.Action != "prima" && r.Action != "durante" {
+				t.Fatalf("voce non valida %+v", r)
+			}
+		}
+	}
+	wg.Wait()
+}
+
+func TestAppendAsyncRecordsLossWhenWriterStalls(t *testing.T) {
+	l, _ := openLog(t, 0, 0)
+	defer l.Close()
+
+	// il disco non risponde: la scrittura resta bloccata sul lock del file
+	l.mu.Lock()
+	total := asyncQueueSize + 2
+	for i := range total {
+		l.AppendAsync(Record{Time: start.Add(time.Duration(i) * time.Second), Zone: "serra", Action: "cmd", Result: "ok"})
+	}
+	lost := l.lost.Load()
+	l.mu.Unlock()
+	if lost == 0 {
+		t.Fatal("nessuna voce contata come persa con la coda piena")
+	}
+
+	l.AppendAsync(Record{Time: start.Add(time.Hour), Zone: "serra", Action: "cmd", Result: "ok"})
+	var records, markers []Record
+	deadline := time.Now().Add(2 * time.Second)
+	for len(records) < total-int(lost)+1 && time.Now().Before(deadline) {
+		records, _ = l.Search(Query{Action: "cmd"})
+		time.Sleep(10 * time.Millisecond)
+	}
+	markers, _ = l.Search(Query{Action: "audit-records-lost"})
+	if len(records) != total-int(lost)+1 {
+		t.Fatalf("%d voci scritte, attese %d", len(records), total-int(lost)+1)
+	}
+	if len(markers) != 1 || markers[0].Params["count"] != float64(lost) {
+		t.Fatalf("perdita non registrata nell'audit log: %+v", markers)
+	}
+}
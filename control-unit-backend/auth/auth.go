@@ -0,0 +1,446 @@
+package auth
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"server/logging"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var logger = logging.For("auth")
+
+// intervallo di pulizia delle sessioni scadute
+const sessionSweepInterval = 10 * time.Minute
+
+// Ruoli in ordine crescente di privilegi: ogni ruolo include i permessi dei precedenti.
+type Role int
+
+const (
+	Viewer Role = iota
+	Operator
+	Admin
+)
+
+func (r Role) String() string {
+	switch r {
+	case Viewer:
+		return "viewer"
+	case Operator:
+		return "operator"
+	case Admin:
+		return "admin"
+	default:
+		return ""
+	}
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	role, err := ParseRole(string(text))
+	*r = role
+	return err
+}
+
+func ParseRole(s string) (Role, error) {
+	for _, role := range []Role{Viewer, Operator, Admin} {
+		if strings.EqualFold(s, role.String()) {
+			return role, nil
+		}
+	}
+	return Viewer, fmt.Errorf("ruolo non valido: %q", s)
+}
+
+var (
+	ErrInvalidCredentials = errors.New("credenziali non valide")
+	ErrUserExists         = errors.New("utente già esistente")
+	ErrNotFound           = errors.New("utente o token non trovato")
+	ErrLastAdmin          = errors.New("deve restare almeno un amministratore")
+)
+
+const (
+	hashIterations = 600_000
+	hashAlgorithm  = "pbkdf2-sha256"
+)
+
+type User struct {
+	Username     string
+	Role         Role
+	PasswordHash string `json:",omitempty"`
+	CreatedAt    time.Time
+}
+
+// Token per l'accesso delle macchine; viene salvato solo l'hash SHA-256 del segreto.
+type Token struct {
+	ID        string
+	Name      string
+	Role      Role
+	Hash      string `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+// Identita' autenticata associata a una richiesta.
+type Principal struct {
+	Name string
+	Role Role
+	Via  string // "session" o "token"
+}
+
+type session struct {
+	principal Principal
+	expires   time.Time
+}
+
+type storeData struct {
+	Users  []User
+	Tokens []Token
+}
+
+// Store mantiene utenti e token su file e le sessioni in memoria: al riavvio gli utenti
+// devono rifare il login, i token restano validi.
+type Store struct {
+	path       string
+	sessionTTL time.Duration
+
+	mu       sync.Mutex
+	data     storeData
+	sessions map[string]session
+}
+
+// Open carica utenti e token da path. Se non esiste alcun utente viene creato l'utente
+// "admin" con la password fornita (o una generata, restituita come secondo valore).
+func Open(path string, sessionTTL time.Duration, adminPassword string) (*Store, string, error) {
+	s := &Store{path: path, sessionTTL: sessionTTL, sessions: make(map[string]session)}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, "", fmt.Errorf("errore lettura utenti: %w", err)
+	default:
+		if err := json.Unmarshal(data, &s.data); err != nil {
+			return nil, "", fmt.Errorf("file utenti non valido %s: %w", path, err)
+		}
+	}
+	if len(s.data.Users) > 0 {
+		return s, "", nil
+	}
+
+	generated := ""
+	if adminPassword == "" {
+		adminPassword = randomString(12)
+		generated = adminPassword
+	}
+	if err := s.AddUser("admin", adminPassword, Admin); err != nil {
+		return nil, "", err
+	}
+	return s, generated, nil
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key, err := pbkdf2.Key(sha256.New, password, salt, hashIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		hashAlgorithm,
+		strconv.Itoa(hashIterations),
+		hex.EncodeToString(salt),
+		hex.EncodeToString(key),
+	}, "$"), nil
+}
+
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := hashPassword(randomString(12))
+	return hash
+})
+
+func checkPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != hashAlgorithm {
+		return false
+	}
+	iterations, err1 := strconv.Atoi(parts[1])
+	salt, err2 := hex.DecodeString(parts[2])
+	expected, err3 := hex.DecodeString(parts[3])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(expected))
+	return err == nil && subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// save scrive il file in modo atomico; va chiamata con il lock.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("errore salvataggio utenti: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("errore salvataggio utenti: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("errore salvataggio utenti: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) AddUser(username, password string, role Role) error {
+	if username == "" || len(password) < 8 {
+		return errors.New("nome utente obbligatorio e password di almeno 8 caratteri")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slices.ContainsFunc(s.data.Users, func(u User) bool { return u.Username == username }) {
+		return ErrUserExists
+	}
+	s.data.Users = append(s.data.Users, User{Username: username, Role: role, PasswordHash: hash, CreatedAt: time.Now()})
+	return s.save()
+}
+
+// UpdateUser cambia ruolo e, se non vuota, password dell'utente; le sue sessioni vengono chiuse.
+func (s *Store) UpdateUser(username, password string, role Role) error {
+	hash := ""
+	if password != "" {
+		if len(password) < 8 {
+			return errors.New("password di almeno 8 caratteri")
+		}
+		var err error
+		if hash, err = hashPassword(password); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.data.Users, func(u User) bool { return u.Username == username })
+	if i < 0 {
+		return ErrNotFound
+	}
+	if s.data.Users[i].Role == Admin && role != Admin && s.adminCount() == 1 {
+		return ErrLastAdmin
+	}
+	s.data.Users[i].Role = role
+	if hash != "" {
+		s.data.Users[i].PasswordHash = hash
+	}
+	s.dropSessions(username)
+	return s.save()
+}
+
+func (s *Store) DeleteUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.data.Users, func(u User) bool { return u.Username == username })
+	if i < 0 {
+		return ErrNotFound
+	}
+	if s.data.Users[i].Role == Admin && s.adminCount() == 1 {
+		return ErrLastAdmin
+	}
+	s.data.Users = slices.Delete(s.data.Users, i, i+1)
+	s.dropSessions(username)
+	return s.save()
+}
+
+func (s *Store) adminCount() int {
+	count := 0
+	for _, u := range s.data.Users {
+		if u.Role == Admin {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Store) dropSessions(username string) {
+	for id, sess := range s.sessions {
+		if sess.principal.Via == "session" && sess.principal.Name == username {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// Users restituisce gli utenti senza l'hash della password.
+func (s *Store) Users() []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := slices.Clone(s.data.Users)
+	for i := range users {
+		users[i].PasswordHash = ""
+	}
+	return users
+}
+
+// Login verifica le credenziali e apre una sessione, di cui restituisce l'identificativo.
+func (s *Store) Login(username, password string) (string, Principal, error) {
+	s.mu.Lock()
+	i := slices.IndexFunc(s.data.Users, func(u User) bool { return u.Username == username })
+	var user User
+	if i >= 0 {
+		user = s.data.Users[i]
+	}
+	s.mu.Unlock()
+
+	// la verifica viene eseguita anche per utenti inesistenti per non rivelarli dai tempi di risposta
+	if i < 0 {
+		user.PasswordHash = dummyHash()
+	}
+	if !checkPassword(user.PasswordHash, password) || i < 0 {
+		return "", Principal{}, ErrInvalidCredentials
+	}
+
+	id := randomString(32)
+	principal := Principal{Name: user.Username, Role: user.Role, Via: "session"}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[id] = session{principal: principal, expires: time.Now().Add(s.sessionTTL)}
+	return id, principal, nil
+}
+
+func (s *Store) Logout(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+}
+
+// Session restituisce l'identita' associata alla sessione e ne rinnova la scadenza.
+func (s *Store) Session(sessionID string) (Principal, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[sessionID]
+	if !ok {
+		return Principal{}, false
+	}
+	now := time.Now()
+	if now.After(sess.expires) {
+		delete(s.sessions, sessionID)
+		return Principal{}, false
+	}
+	sess.expires = now.Add(s.sessionTTL)
+	s.sessions[sessionID] = sess
+	return sess.principal, true
+}
+
+// ExpireSessions elimina le sessioni scadute e restituisce quante ne ha eliminate.
+func (s *Store) ExpireSessions(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired := 0
+	for id, sess := range s.sessions {
+		if now.After(sess.expires) {
+			delete(s.sessions, id)
+			expired++
+		}
+	}
+	return expired
+}
+
+// Run elimina periodicamente le sessioni scadute e mai piu' usate, che Session rimuove solo
+// quando vengono presentate, finche' ctx non viene cancellato.
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(sessionSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if n := s.ExpireSessions(now); n > 0 {
+				logger.Debug("expired sessions removed", "count", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// CreateToken genera un nuovo token; il segreto viene restituito solo in questo momento.
+func (s *Store) CreateToken(name string, role Role) (Token, string, error) {
+	secret := randomString(32)
+	token := Token{ID: randomString(6), Name: name, Role: role, Hash: hashToken(secret), CreatedAt: time.Now()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Tokens = append(s.data.Tokens, token)
+	if err := s.save(); err != nil {
+		s.data.Tokens = s.data.Tokens[:len(s.data.Tokens)-1]
+		return Token{}, "", err
+	}
+	token.Hash = ""
+	return token, token.ID + "." + secret, nil
+}
+
+func (s *Store) Tokens() []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := slices.Clone(s.data.Tokens)
+	for i := range tokens {
+		tokens[i].Hash = ""
+	}
+	return tokens
+}
+
+func (s *Store) DeleteToken(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.data.Tokens, func(t Token) bool { return t.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.data.Tokens = slices.Delete(s.data.Tokens, i, i+1)
+	return s.save()
+}
+
+// VerifyToken controlla un token nel formato "<id>.<segreto>".
+func (s *Store) VerifyToken(value string) (Principal, bool) {
+	id, secret, ok := strings.Cut(value, ".")
+	if !ok {
+		return Principal{}, false
+	}
+	hash := hashToken(secret)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.data.Tokens {
+		if t.ID == id && subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) == 1 {
+			return Principal{Name: "token:" + t.Name, Role: t.Role, Via: "token"}, true
+		}
+	}
+	return Principal{}, false
+}
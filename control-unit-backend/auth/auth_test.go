@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpireSessionsRemovesOnlyExpired(t *testing.T) {
+	s, generated, err := Open(filepath.Join(t.TempDir(), "users.json"), time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generated == "" {
+		t.Fatal("password dell'admin non generata")
+	}
+	old, _, err := s.Login("admin", generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent, _, err := s.Login("admin", generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// la prima sessione e' stata usata l'ultima volta due ore fa
+	s.mu.Lock()
+	sess := s.sessions[old]
+	sess.expires = time.Now().Add(-time.Hour)
+	s.sessions[old] = sess
+	s.mu.Unlock()
+
+	if n := s.ExpireSessions(time.Now()); n != 1 {
+		t.Fatalf("%d sessioni eliminate invece di 1", n)
+	}
+	if _, ok := s.Session(old); ok {
+		t.Fatal("sessione scaduta ancora valida")
+	}
+	if _, ok := s.Session(recent); !ok {
+		t.Fatal("sessione attiva eliminata")
+	}
+	if n := s.ExpireSessions(time.Now().Add(2 * time.Hour)); n != 1 {
+		t.Fatalf("%d sessioni eliminate dopo la scadenza di tutte", n)
+	}
+}
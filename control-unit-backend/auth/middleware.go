@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const SessionCookie = "control_unit_session"
+
+type principalKey struct{}
+
+// FromContext restituisce l'identita' autenticata dal middleware Require.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// Authenticate riconosce la richiesta dal token "Authorization: Bearer" o dal cookie di sessione.
+func (s *Store) Authenticate(r *http.Request) (Principal, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		value, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return Principal{}, false
+		}
+		return s.VerifyToken(value)
+	}
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return Principal{}, false
+	}
+	return s.Session(cookie.Value)
+}
+
+// Require lascia passare solo le richieste autenticate con un ruolo almeno pari a role.
+// Con uno Store nil l'autenticazione e' disabilitata e ogni richiesta ha il ruolo admin.
+func (s *Store) Require(role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal := Principal{Name: "anonymous", Role: Admin}
+		if s != nil {
+			var ok bool
+			if principal, ok = s.Authenticate(r); !ok {
+				http.Error(w, "Autenticazione richiesta", http.StatusUnauthorized)
+				return
+			}
+			if principal.Role < role {
+				http.Error(w, "Permessi insufficienti", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+// SetSessionCookie invia al browser il cookie della sessione appena aperta.
+func (s *Store) SetSessionCookie(w http.ResponseWriter, sessionID string, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    sessionID,
+		Path:     "/",
+		Expires:  time.Now().Add(s.sessionTTL),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: SessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+}
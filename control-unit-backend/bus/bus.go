@@ -0,0 +1,182 @@
+// Package bus implementa un bus interno publish/subscribe con topic tipizzati.
+//
+// Ogni sottoscrittore sceglie come gestire un buffer pieno: Block rallenta chi pubblica,
+// DropOldest scarta il valore piu' vecchio, Coalesce tiene solo l'ultimo valore.
+// I contatori di ogni topic e sottoscrittore sono esposti come metriche.
+package bus
+
+import (
+	"context"
+	"server/logging"
+	"server/metrics"
+	"slices"
+	"sync"
+	"time"
+)
+
+type Policy int
+
+const (
+	Block      Policy = iota // chi pubblica attende che ci sia posto nel buffer
+	DropOldest               // con il buffer pieno viene scartato il valore piu' vecchio
+	Coalesce                 // buffer di un solo valore, sostituito da quello piu' recente
+)
+
+func (p Policy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropOldest:
+		return "drop-oldest"
+	case Coalesce:
+		return "coalesce"
+	default:
+		return ""
+	}
+}
+
+var logger = logging.For("bus")
+
+var (
+	publishedCounter = metrics.NewCounterVec("control_unit_bus_published_total",
+		"Valori pubblicati su un topic del bus.", "topic")
+	deliveredCounter = metrics.NewCounterVec("control_unit_bus_delivered_total",
+		"Valori consegnati al buffer di un sottoscrittore.", "topic", "subscriber")
+	droppedCounter = metrics.NewCounterVec("control_unit_bus_dropped_total",
+		"Valori scartati o sostituiti per buffer pieno.", "topic", "subscriber")
+	blockedCounter = metrics.NewCounterVec("control_unit_bus_blocked_seconds_total",
+		"Tempo passato da chi pubblica in attesa di un sottoscrittore Block.", "topic", "subscriber")
+)
+
+// Topic distribuisce i valori di tipo T a tutti i sottoscrittori.
+type Topic[T any] struct {
+	name      string
+	published *metrics.Counter
+
+	mu   sync.RWMutex
+	subs []*Subscription[T]
+}
+
+func NewTopic[T any](name string) *Topic[T] {
+	return &Topic[T]{name: name, published: publishedCounter.With(name)}
+}
+
+func (t *Topic[T]) Name() string { return t.name }
+
+type Subscription[T any] struct {
+	// C riceve i valori pubblicati sul topic.
+	C <-chan T
+
+	c      chan T
+	name   string
+	policy Policy
+	topic  *Topic[T]
+	mu     sync.Mutex // rende atomico lo scarto e l'inserimento tra piu' publisher
+	closed chan struct{}
+	once   sync.Once
+
+	delivered *metrics.Counter
+	dropped   *metrics.Counter
+	blocked   *metrics.Counter
+}
+
+// Subscribe registra un sottoscrittore; name identifica il sottoscrittore nelle metriche.
+// Con Coalesce la dimensione del buffer e' sempre 1, con le altre politiche almeno 0.
+func (t *Topic[T]) Subscribe(name string, policy Policy, buffer int) *Subscription[T] {
+	if policy == Coalesce {
+		buffer = 1
+	}
+	if policy == DropOldest {
+		buffer = max(buffer, 1)
+	}
+	c := make(chan T, max(buffer, 0))
+	s := &Subscription[T]{
+		C:         c,
+		c:         c,
+		name:      name,
+		policy:    policy,
+		topic:     t,
+		closed:    make(chan struct{}),
+		delivered: deliveredCounter.With(t.name, name),
+		dropped:   droppedCounter.With(t.name, name),
+		blocked:   blockedCounter.With(t.name, name),
+	}
+	t.mu.Lock()
+	t.subs = append(t.subs, s)
+	t.mu.Unlock()
+	return s
+}
+
+// Unsubscribe rimuove il sottoscrittore; i publisher in attesa su di esso vengono sbloccati.
+func (s *Subscription[T]) Unsubscribe() {
+	s.once.Do(func() { close(s.closed) })
+	t := s.topic
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.subs = slices.DeleteFunc(t.subs, func(other *Subscription[T]) bool { return other == s })
+}
+
+// Publish consegna v a tutti i sottoscrittori; puo' bloccare solo per quelli con politica Block.
+func (t *Topic[T]) Publish(v T) {
+	t.PublishContext(context.Background(), v)
+}
+
+// PublishContext e' come Publish ma rinuncia ad attendere i sottoscrittori Block quando
+// ctx viene cancellato, restituendone l'errore. I sottoscrittori non bloccanti ricevono
+// comunque il valore.
+func (t *Topic[T]) PublishContext(ctx context.Context, v T) error {
+	t.published.Inc()
+	t.mu.RLock()
+	subs := slices.Clone(t.subs)
+	t.mu.RUnlock()
+
+	var err error
+	for _, s := range subs {
+		if e := s.deliver(ctx, v); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (s *Subscription[T]) deliver(ctx context.Context, v T) error {
+	if s.policy == Block {
+		select {
+		case s.c <- v:
+			s.delivered.Inc()
+			return nil
+		default:
+		}
+		start := time.Now()
+		defer func() { s.blocked.Add(time.Since(start).Seconds()) }()
+		select {
+		case s.c <- v:
+			s.delivered.Inc()
+			return nil
+		case <-s.closed:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		select {
+		case s.c <- v:
+			s.delivered.Inc()
+			return nil
+		default:
+		}
+		// il lettore puo' aver svuotato il buffer nel frattempo: si riprova l'inserimento
+		select {
+		case <-s.c:
+			s.dropped.Inc()
+			if s.policy == DropOldest {
+				logger.Warn("buffer full, dropped oldest value", "topic", s.topic.name, "subscriber", s.name)
+			}
+		default:
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func drain[T any](c <-chan T) []T {
+	var values []T
+	for {
+		select {
+		case v := <-c:
+			values = append(values, v)
+		default:
+			return values
+		}
+	}
+}
+
+func TestDropOldestKeepsNewestValues(t *testing.T) {
+	topic := NewTopic[int]("test-drop-oldest")
+	sub := topic.Subscribe("lettore", DropOldest, 3)
+	for i := 1; i <= 5; i++ {
+		topic.Publish(i)
+	}
+	if got := drain(sub.C); len(got) != 3 || got[0] != 3 || got[2] != 5 {
+		t.Fatalf("valori ricevuti %v, attesi [3 4 5]", got)
+	}
+	if sub.dropped.Value() != 2 || sub.delivered.Value() != 5 {
+		t.Fatalf("scartati %v, consegnati %v", sub.dropped.Value(), sub.delivered.Value())
+	}
+}
+
+func TestCoalesceKeepsOnlyLatest(t *testing.T) {
+	topic := NewTopic[string]("test-coalesce")
+	// la dimensione richiesta viene ignorata: il buffer e' sempre di un valore
+	sub := topic.Subscribe("lettore", Coalesce, 10)
+	topic.Publish("a")
+	topic.Publish("b")
+	topic.Publish("c")
+	if got := drain(sub.C); len(got) != 1 || got[0] != "c" {
+		t.Fatalf("valori ricevuti %v, atteso solo l'ultimo", got)
+	}
+}
+
+func TestBlockWaitsForSubscriber(t *testing.T) {
+	topic := NewTopic[int]("test-block")
+	sub := topic.Subscribe("lettore", Block, 1)
+	topic.Publish(1)
+
+	published := make(chan struct{})
+	go func() {
+		topic.Publish(2)
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("Publish non ha atteso il sottoscrittore con il buffer pieno")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if v := <-sub.C; v != 1 {
+		t.Fatalf("ricevuto %d invece di 1", v)
+	}
+	<-published
+	if v := <-sub.C; v != 2 {
+		t.Fatalf("ricevuto %d invece di 2", v)
+	}
+	if sub.dropped.Value() != 0 {
+		t.Fatal("valori scartati con la politica Block")
+	}
+}
+
+func TestPublishContextGivesUpOnBlockedSubscriber(t *testing.T) {
+	topic := NewTopic[int]("test-block-context")
+	blocked := topic.Subscribe("bloccato", Block, 0)
+	latest := topic.Subscribe("ultimo", Coalesce, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := topic.PublishContext(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("errore %v, atteso il timeout del context", err)
+	}
+	// gli altri sottoscrittori ricevono comunque il valore
+	if got := drain(latest.C); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("sottoscrittore non bloccante: %v", got)
+	}
+	if got := drain(blocked.C); len(got) != 0 {
+		t.Fatalf("sottoscrittore bloccato: %v", got)
+	}
+}
+
+func TestUnsubscribeReleasesBlockedPublisher(t *testing.T) {
+	topic := NewTopic[int]("test-unsubscribe")
+	sub := topic.Subscribe("lettore", Block, 0)
+
+	published := make(chan struct{})
+	go func() {
+		topic.Publish(1)
+		close(published)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	sub.Unsubscribe()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("Publish ancora bloccato dopo Unsubscribe")
+	}
+	// senza sottoscrittori Publish non blocca
+	topic.Publish(2)
+}
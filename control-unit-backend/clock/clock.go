@@ -0,0 +1,44 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock astrae l'ora corrente, cosi' la logica del sistema puo' girare anche in tempo simulato.
+type Clock interface {
+	Now() time.Time
+}
+
+// Real legge l'orologio di sistema.
+type Real struct{}
+
+func (Real) Now() time.Time { return time.Now() }
+
+// Fake e' un orologio che avanza solo quando richiesto.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = t
+}
@@ -0,0 +1,130 @@
+// simulate esegue la logica di controllo della finestra su una stanza simulata
+// e scrive la traiettoria temperatura/finestra/stato in CSV, una serie per strategia.
+//
+//	go run ./cmd/simulate -duration 24h -strategy linear,pid > traiettorie.csv
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"server/clock"
+	"server/config"
+	"server/sim"
+	"server/system"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func main() {
+	var (
+		configPath = flag.String("config", "config.json", "percorso del file di configurazione")
+		zoneID     = flag.String("zone", "", "zona da simulare (default: la prima)")
+		strategies = flag.String("strategy", "", "strategie da confrontare separate da virgola (default: quella della zona)")
+		duration   = flag.Duration("duration", 24*time.Hour, "tempo simulato")
+		every      = flag.Duration("every", time.Minute, "intervallo tra due righe del CSV")
+		startFlag  = flag.String("start", "2025-07-01T00:00:00Z", "istante iniziale simulato (RFC3339)")
+		output     = flag.String("o", "", "file CSV di uscita (default: stdout)")
+		verbose    = flag.Bool("v", false, "mostra i log del sistema durante la simulazione")
+	)
+	params := sim.DefaultRoomParams()
+	flag.Float64Var(&params.InitialTemp, "initial", params.InitialTemp, "temperatura iniziale della stanza")
+	flag.Float64Var(&params.OutsideTemp, "outside", params.OutsideTemp, "temperatura esterna media")
+	flag.Float64Var(&params.OutsideAmplitude, "amplitude", params.OutsideAmplitude, "escursione giornaliera della temperatura esterna")
+	flag.Float64Var(&params.HeatGain, "gain", params.HeatGain, "apporto di calore interno in °C/s")
+	flag.Float64Var(&params.SensorNoise, "noise", params.SensorNoise, "rumore del sensore (deviazione standard)")
+	flag.Int64Var(&params.Seed, "seed", params.Seed, "seme del generatore casuale")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	zoneConfig := cfg.Zones[0]
+	if *zoneID != "" {
+		found := false
+		for _, z := range cfg.Zones {
+			if z.ID == *zoneID {
+				zoneConfig, found = z, true
+			}
+		}
+		if !found {
+			log.Fatalf("zona sconosciuta: %s", *zoneID)
+		}
+	}
+	start, err := time.Parse(time.RFC3339, *startFlag)
+	if err != nil {
+		log.Fatalf("istante iniziale non valido: %v", err)
+	}
+
+	names := []string{zoneConfig.Control.Strategy}
+	if *strategies != "" {
+		names = strings.Split(*strategies, ",")
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"strategy", "time_s", "temperature", "measured", "outside", "window_command", "window_position", "status"})
+
+	zone := sim.ZoneParams{
+		Threshold1:        zoneConfig.Threshold1,
+		Threshold2:        zoneConfig.Threshold2,
+		NormalFreq:        zoneConfig.NormalFreq.Std(),
+		FastFreq:          zoneConfig.FastFreq.Std(),
+		TooHotMaxDuration: zoneConfig.TooHotMaxDuration.Std(),
+	}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		strategy, err := system.NewWindowStrategy(system.StrategyConfig{
+			Name:     name,
+			Setpoint: zoneConfig.Control.Setpoint,
+			Kp:       zoneConfig.Control.Kp,
+			Ki:       zoneConfig.Control.Ki,
+			Kd:       zoneConfig.Control.Kd,
+			MaxRate:  zoneConfig.Control.MaxRate,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		nextRow := time.Duration(0)
+		sim.RunClosedLoop(sim.NewRoom(params), zone, strategy, clock.NewFake(start), *duration, func(s sim.Sample) {
+			if s.Elapsed < nextRow {
+				return
+			}
+			nextRow += *every
+			w.Write([]string{
+				name,
+				strconv.FormatFloat(s.Elapsed.Seconds(), 'f', 1, 64),
+				strconv.FormatFloat(s.Temperature, 'f', 3, 64),
+				strconv.FormatFloat(s.Measured, 'f', 3, 64),
+				strconv.FormatFloat(s.Outside, 'f', 3, 64),
+				strconv.Itoa(int(s.Command)),
+				strconv.FormatFloat(s.Position, 'f', 1, 64),
+				s.Status,
+			})
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
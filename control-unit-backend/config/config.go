@@ -0,0 +1,412 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Duration permette di scrivere le durate nel file di configurazione come stringhe ("3s", "500ms").
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("durata non valida %s: %w", data, err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("durata non valida %q: %w", s, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) Std() time.Duration { return time.Duration(d) }
+
+type MQTT struct {
+	Broker             string
+	ClientID           string
+	Username           string
+	Password           string // se vuoto viene letta la variabile d'ambiente MQTT_PASSWORD
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+	CleanSession       bool
+	KeepAlive          Duration
+	ConnectTimeout     Duration
+	OutboxCapacity     int
+	OutboxPath         string // se vuoto la coda dei messaggi in uscita resta solo in memoria
+}
+
+// Controlli di plausibilita' sulle letture e fusione dei sensori di temperatura.
+type Sensor struct {
+	MinValid          float64
+	MaxValid          float64
+	MaxRatePerSecond  float64
+	StuckDuration     Duration
+	StuckTolerance    float64
+	FaultClearSamples int
+	FaultPolicy       string // "hold", "open" o "alarm"
+	Fusion            string // "mean", "median", "max" o "weighted"
+	Weights           map[string]float64
+	Timeout           Duration
+	MaxAge            Duration
+	RejectStale       bool // scarta i campioni oltre MaxAge o fuori ordine invece di contarli soltanto
+}
+
+// Zona (stanza) con i propri sensori, finestra, soglie, modalita' e allarme.
+type Zone struct {
+	ID                string
+	Name              string
+	Threshold1        float64
+	Threshold2        float64
+	NormalFreq        Duration
+	FastFreq          Duration
+	TooHotMaxDuration Duration
+	ManualLease       Duration           // dopo questo tempo senza comandi manuali si torna in automatico; negativo disabilita
+	SerialPort        string             // vuoto: ricerca automatica della porta di Arduino
+	SensorWeights     map[string]float64 // se presente sostituisce Sensor.Weights
+	Control           Control
+}
+
+// Strategia di controllo della finestra: "linear" (mappatura storica) o "pid".
+type Control struct {
+	Strategy string
+	Setpoint float64
+	Kp       float64
+	Ki       float64
+	Kd       float64
+	MaxRate  float64 // gradi al secondo; negativo disabilita il limite
+}
+
+// Gestione degli allarmi, comune a tutte le zone.
+type Alarms struct {
+	EscalateAfter Duration // un allarme non riconosciuto sale di severità dopo questo tempo; "0s" disabilita
+	HistorySize   int
+	OfflineDelay  Duration
+}
+
+// Supervisione dell'attuatore della finestra.
+type Actuator struct {
+	Timeout    Duration // tempo concesso per raggiungere la posizione comandata
+	Tolerance  int      // gradi
+	MaxRetries int
+}
+
+// Canali di notifica degli allarmi. Le severità sono "WARNING", "MAJOR" o "CRITICAL";
+// se vuote vengono notificati tutti gli allarmi.
+type Notifications struct {
+	Webhooks   []Webhook
+	Push       []Push
+	SMTP       SMTP
+	Retries    int
+	RetryDelay Duration
+	RateLimit  Duration // intervallo minimo tra due notifiche uguali
+	MaxPerHour int
+	QuietHours QuietHours
+}
+
+type Webhook struct {
+	URL         string
+	Secret      string // chiave HMAC-SHA256 con cui viene firmato il corpo
+	MinSeverity string
+}
+
+// Endpoint compatibile con ntfy.
+type Push struct {
+	URL         string
+	Token       string
+	MinSeverity string
+}
+
+// Con Host vuoto le email sono disabilitate.
+type SMTP struct {
+	Host        string
+	Port        int
+	Username    string
+	Password    string // se vuoto viene letta la variabile d'ambiente SMTP_PASSWORD
+	From        string
+	To          []string
+	MinSeverity string
+}
+
+// Nella fascia Start-End ("HH:MM") vengono inviate solo le notifiche con severità almeno MinSeverity.
+type QuietHours struct {
+	Start       string
+	End         string
+	MinSeverity string
+}
+
+type API struct {
+	AllowedOrigins []string // origini ammesse per CORS; vuoto: solo la dashboard servita dallo stesso server
+	Auth           Auth
+}
+
+// Autenticazione delle API. Al primo avvio viene creato l'utente "admin" con AdminPassword
+// (variabile d'ambiente ADMIN_PASSWORD se vuota, altrimenti generata e scritta nel log).
+type Auth struct {
+	Enabled       bool
+	UsersPath     string
+	SessionTTL    Duration
+	AdminPassword string
+	SecureCookies bool // da abilitare se le API sono servite in HTTPS
+}
+
+// Audit log dei comandi e delle azioni degli operatori.
+type Audit struct {
+	Path      string
+	MaxSizeMB int // dimensione oltre la quale il file viene ruotato
+	MaxFiles  int // file ruotati conservati
+}
+
+// Logging strutturato: Format "text" o "json", Level "debug", "info", "warn" o "error".
+type Log struct {
+	Format    string
+	Level     string
+	RateLimit Duration // intervallo minimo tra due avvisi uguali; "0s" disabilita
+}
+
+// Riavvio delle goroutine dei servizi e arresto ordinato.
+type Supervisor struct {
+	MinBackoff  Duration
+	MaxBackoff  Duration
+	StopTimeout Duration // attesa massima per l'arresto di ogni servizio
+	ResetAfter  Duration // dopo questo tempo senza errori il backoff riparte dal minimo
+}
+
+// Salvataggio su disco dello stato di esecuzione delle zone (modalita', allarme, lease
+// manuale, statistiche), ripristinato all'avvio.
+type State struct {
+	Dir      string   // vuoto disabilita il salvataggio
+	Interval Duration // salvataggio periodico, oltre a quello ad ogni cambio di modalita' o allarme
+	MaxAge   Duration // modalita' e statistiche piu' vecchie vengono ignorate; "0s" nessun limite
+	// "always": rientra in Alarm se lo era, "recent": solo entro MaxAge, "never"
+	RestoreAlarm string
+	// "restore": ripristina la modalita' manuale se la lease non e' scaduta, "automatic"
+	RestoreMode       string
+	RestoreStatistics bool
+}
+
+const DefaultZoneID = "default"
+
+type Config struct {
+	MQTT   MQTT
+	Sensor Sensor
+	// La prima zona e' quella di default, raggiungibile anche dalle API e dai topic storici.
+	Zones         []Zone
+	Alarms        Alarms
+	Actuator      Actuator
+	Notifications Notifications
+	API           API
+	Audit         Audit
+	Log           Log
+	Supervisor    Supervisor
+	State         State
+	// File in cui viene salvato il calendario delle modalita' e dei profili di soglie.
+	SchedulesPath string
+}
+
+func defaultZone(id string) Zone {
+	return Zone{
+		ID:                id,
+		Name:              id,
+		Threshold1:        30,
+		Threshold2:        70,
+		NormalFreq:        Duration(500 * time.Millisecond),
+		FastFreq:          Duration(100 * time.Millisecond),
+		TooHotMaxDuration: Duration(10 * time.Second),
+		ManualLease:       Duration(30 * time.Minute),
+		Control: Control{
+			Strategy: "linear",
+			Setpoint: 25,
+			Kp:       8,
+			Ki:       0.05,
+			Kd:       0,
+			MaxRate:  10,
+		},
+	}
+}
+
+// withDefaults completa i campi non impostati del controllo. I guadagni del PID vengono
+// completati solo se mancano tutti, cosi' che si possa configurare un controllore P o PI.
+func (c Control) withDefaults(def Control) Control {
+	if c.Strategy == "" {
+		c.Strategy = def.Strategy
+	}
+	if c.Setpoint == 0 {
+		c.Setpoint = def.Setpoint
+	}
+	if c.Kp == 0 && c.Ki == 0 && c.Kd == 0 {
+		c.Kp, c.Ki, c.Kd = def.Kp, def.Ki, def.Kd
+	}
+	if c.MaxRate == 0 {
+		c.MaxRate = def.MaxRate
+	}
+	return c
+}
+
+// completa le zone con i valori di default e ne verifica la coerenza
+func (c *Config) normalizeZones() error {
+	if len(c.Zones) == 0 {
+		c.Zones = []Zone{defaultZone(DefaultZoneID)}
+		return nil
+	}
+	seen := make(map[string]bool, len(c.Zones))
+	autoPorts := 0
+	for i := range c.Zones {
+		zone := &c.Zones[i]
+		if zone.ID == "" {
+			return fmt.Errorf("zona %d senza ID", i)
+		}
+		if seen[zone.ID] {
+			return fmt.Errorf("zona %q duplicata", zone.ID)
+		}
+		seen[zone.ID] = true
+
+		def := defaultZone(zone.ID)
+		if zone.Name == "" {
+			zone.Name = def.Name
+		}
+		if zone.Threshold1 == 0 && zone.Threshold2 == 0 {
+			zone.Threshold1, zone.Threshold2 = def.Threshold1, def.Threshold2
+		}
+		if zone.NormalFreq == 0 {
+			zone.NormalFreq = def.NormalFreq
+		}
+		if zone.FastFreq == 0 {
+			zone.FastFreq = def.FastFreq
+		}
+		if zone.TooHotMaxDuration == 0 {
+			zone.TooHotMaxDuration = def.TooHotMaxDuration
+		}
+		if zone.ManualLease == 0 {
+			zone.ManualLease = def.ManualLease
+		}
+		zone.Control = zone.Control.withDefaults(def.Control)
+		if zone.Threshold1 >= zone.Threshold2 {
+			return fmt.Errorf("zona %q: Threshold1 deve essere minore di Threshold2", zone.ID)
+		}
+		if zone.SerialPort == "" {
+			autoPorts++
+		}
+	}
+	if autoPorts > 1 {
+		return fmt.Errorf("solo una zona può usare la ricerca automatica della porta seriale")
+	}
+	return nil
+}
+
+func Default() Config {
+	return Config{
+		MQTT: MQTT{
+			Broker:         "tcp://localhost:1883",
+			ClientID:       "iot-server",
+			CleanSession:   true,
+			KeepAlive:      Duration(3 * time.Second),
+			ConnectTimeout: Duration(30 * time.Second),
+			OutboxCapacity: 200,
+		},
+		// il DS18B20 riporta 85°C all'accensione e -127°C se scollegato
+		Sensor: Sensor{
+			MinValid:          -40,
+			MaxValid:          84,
+			MaxRatePerSecond:  5,
+			StuckDuration:     Duration(15 * time.Minute),
+			StuckTolerance:    0.01,
+			FaultClearSamples: 5,
+			FaultPolicy:       "hold",
+			Fusion:            "mean",
+			Timeout:           Duration(2 * time.Second),
+			MaxAge:            Duration(5 * time.Second),
+		},
+		SchedulesPath: "data/schedules.json",
+		Alarms: Alarms{
+			EscalateAfter: Duration(15 * time.Minute),
+			HistorySize:   200,
+			OfflineDelay:  Duration(30 * time.Second),
+		},
+		Actuator: Actuator{
+			Timeout:    Duration(15 * time.Second),
+			Tolerance:  5,
+			MaxRetries: 2,
+		},
+		Notifications: Notifications{
+			SMTP:       SMTP{Port: 587},
+			Retries:    3,
+			RetryDelay: Duration(5 * time.Second),
+			RateLimit:  Duration(5 * time.Minute),
+			MaxPerHour: 30,
+			QuietHours: QuietHours{MinSeverity: "CRITICAL"},
+		},
+		Audit: Audit{
+			Path:      "data/audit.log",
+			MaxSizeMB: 5,
+			MaxFiles:  5,
+		},
+		Log: Log{
+			Format:    "text",
+			Level:     "info",
+			RateLimit: Duration(30 * time.Second),
+		},
+		Supervisor: Supervisor{
+			MinBackoff:  Duration(1 * time.Second),
+			MaxBackoff:  Duration(1 * time.Minute),
+			StopTimeout: Duration(5 * time.Second),
+			ResetAfter:  Duration(1 * time.Minute),
+		},
+		State: State{
+			Dir:               "data/state",
+			Interval:          Duration(30 * time.Second),
+			MaxAge:            Duration(1 * time.Hour),
+			RestoreAlarm:      "always",
+			RestoreMode:       "restore",
+			RestoreStatistics: true,
+		},
+		API: API{
+			Auth: Auth{
+				Enabled:    true,
+				UsersPath:  "data/users.json",
+				SessionTTL: Duration(12 * time.Hour),
+			},
+		},
+	}
+}
+
+// Load legge il file di configurazione JSON sovrascrivendo i valori di default.
+// Se il file non esiste restituisce la configurazione di default.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return cfg, fmt.Errorf("errore lettura configurazione: %w", err)
+	default:
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("errore nel file di configurazione %s: %w", path, err)
+		}
+	}
+	if cfg.MQTT.Password == "" {
+		cfg.MQTT.Password = os.Getenv("MQTT_PASSWORD")
+	}
+	if cfg.API.Auth.AdminPassword == "" {
+		cfg.API.Auth.AdminPassword = os.Getenv("ADMIN_PASSWORD")
+	}
+	if cfg.Notifications.SMTP.Password == "" {
+		cfg.Notifications.SMTP.Password = os.Getenv("SMTP_PASSWORD")
+	}
+	if err := cfg.normalizeZones(); err != nil {
+		return cfg, fmt.Errorf("errore nel file di configurazione %s: %w", path, err)
+	}
+	return cfg, nil
+}
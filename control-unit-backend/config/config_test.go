@@ -0,0 +1,34 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPartialControlKeepsDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"Zones": [
+		{"ID": "serra", "Control": {"Strategy": "pid"}},
+		{"ID": "ufficio", "SerialPort": "/dev/ttyACM1", "Control": {"Strategy": "pid", "Setpoint": 22, "Kp": 5, "MaxRate": -1}}
+	]}`), 0o644)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := defaultZone("").Control
+
+	serra := cfg.Zones[0].Control
+	want := def
+	want.Strategy = "pid"
+	if serra != want {
+		t.Errorf("controllo della serra %+v, atteso %+v", serra, want)
+	}
+
+	// i guadagni impostati non vengono completati: il controllore resta solo proporzionale
+	ufficio := cfg.Zones[1].Control
+	want = Control{Strategy: "pid", Setpoint: 22, Kp: 5, MaxRate: -1}
+	if ufficio != want {
+		t.Errorf("controllo dell'ufficio %+v, atteso %+v", ufficio, want)
+	}
+}
@@ -0,0 +1,179 @@
+// Package logging configura il logging strutturato (log/slog) del server.
+//
+// Ogni sottosistema ottiene il proprio logger con For; il livello e' globale e puo'
+// essere cambiato a runtime con SetLevel. I messaggi di livello WARN o superiore che
+// si ripetono dallo stesso punto del codice vengono limitati: ne passa uno per
+// intervallo e il successivo riporta quanti ne sono stati soppressi.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	level   = new(slog.LevelVar)
+	handler atomic.Pointer[slog.Handler]
+)
+
+func init() {
+	h := slog.Default().Handler()
+	handler.Store(&h)
+}
+
+// Setup imposta formato ("text" o "json"), livello e intervallo di rate limiting
+// (0 disabilita) e installa il logger anche come default di slog e del package log.
+func Setup(w io.Writer, format, levelName string, rateLimit time.Duration) error {
+	if err := SetLevel(levelName); err != nil {
+		return err
+	}
+	options := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		h = slog.NewTextHandler(w, options)
+	case "json":
+		h = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("formato di log non valido: %q", format)
+	}
+	if rateLimit > 0 {
+		h = newRateLimiter(h, rateLimit)
+	}
+	handler.Store(&h)
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// Level restituisce il livello corrente.
+func Level() slog.Level {
+	return level.Level()
+}
+
+// SetLevel accetta "debug", "info", "warn", "error" (anche con offset, es. "info+2").
+func SetLevel(name string) error {
+	if name == "" {
+		name = "info"
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return fmt.Errorf("livello di log non valido: %q", name)
+	}
+	level.Set(l)
+	return nil
+}
+
+// For restituisce il logger di un sottosistema. Puo' essere chiamata prima di Setup
+// (ad esempio per inizializzare variabili di package): i messaggi vengono inoltrati
+// all'handler configurato al momento della scrittura.
+func For(subsystem string) *slog.Logger {
+	return slog.New(dynamicHandler{}).With("subsystem", subsystem)
+}
+
+// dynamicHandler inoltra i record all'handler corrente, riapplicando attributi e gruppi.
+type dynamicHandler struct {
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (d dynamicHandler) current() slog.Handler {
+	h := *handler.Load()
+	for _, op := range d.ops {
+		h = op(h)
+	}
+	return h
+}
+
+func (d dynamicHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return (*handler.Load()).Enabled(ctx, l)
+}
+
+func (d dynamicHandler) Handle(ctx context.Context, r slog.Record) error {
+	return d.current().Handle(ctx, r)
+}
+
+func (d dynamicHandler) with(op func(slog.Handler) slog.Handler) dynamicHandler {
+	ops := make([]func(slog.Handler) slog.Handler, len(d.ops), len(d.ops)+1)
+	copy(ops, d.ops)
+	return dynamicHandler{ops: append(ops, op)}
+}
+
+func (d dynamicHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return d.with(func(h slog.Handler) slog.Handler { return h.WithAttrs(attrs) })
+}
+
+func (d dynamicHandler) WithGroup(name string) slog.Handler {
+	return d.with(func(h slog.Handler) slog.Handler { return h.WithGroup(name) })
+}
+
+type limiterEntry struct {
+	last       time.Time
+	suppressed int
+}
+
+type limiterState struct {
+	mu      sync.Mutex
+	entries map[string]*limiterEntry
+}
+
+// rateLimiter sopprime i record WARN+ ripetuti; la chiave e' composta dagli attributi
+// del logger (sottosistema, zona, ...), dal punto di chiamata e dal messaggio.
+type rateLimiter struct {
+	next     slog.Handler
+	interval time.Duration
+	prefix   string
+	state    *limiterState
+}
+
+func newRateLimiter(next slog.Handler, interval time.Duration) *rateLimiter {
+	return &rateLimiter{next: next, interval: interval, state: &limiterState{entries: make(map[string]*limiterEntry)}}
+}
+
+func (l *rateLimiter) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return l.next.Enabled(ctx, lvl)
+}
+
+func (l *rateLimiter) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		return l.next.Handle(ctx, r)
+	}
+	key := fmt.Sprintf("%s|%x|%s", l.prefix, r.PC, r.Message)
+
+	l.state.mu.Lock()
+	entry, ok := l.state.entries[key]
+	if !ok {
+		entry = &limiterEntry{}
+		l.state.entries[key] = entry
+	}
+	if ok && r.Time.Sub(entry.last) < l.interval {
+		entry.suppressed++
+		l.state.mu.Unlock()
+		return nil
+	}
+	suppressed := entry.suppressed
+	entry.last, entry.suppressed = r.Time, 0
+	l.state.mu.Unlock()
+
+	if suppressed > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Int("suppressed", suppressed))
+	}
+	return l.next.Handle(ctx, r)
+}
+
+func (l *rateLimiter) WithAttrs(attrs []slog.Attr) slog.Handler {
+	prefix := l.prefix
+	for _, a := range attrs {
+		prefix += a.String() + " "
+	}
+	return &rateLimiter{next: l.next.WithAttrs(attrs), interval: l.interval, prefix: prefix, state: l.state}
+}
+
+func (l *rateLimiter) WithGroup(name string) slog.Handler {
+	return &rateLimiter{next: l.next.WithGroup(name), interval: l.interval, prefix: l.prefix + name + ".", state: l.state}
+}
@@ -0,0 +1,271 @@
+// Package metrics implementa contatori e gauge esposti nel formato testuale di Prometheus,
+// senza dipendenze esterne.
+//
+// Le metriche vengono dichiarate come variabili di package nel sottosistema che le aggiorna
+// e registrate automaticamente; Handler le espone tutte.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// value e' un float64 aggiornabile in modo atomico.
+type value struct {
+	bits atomic.Uint64
+}
+
+func (v *value) load() float64 {
+	return math.Float64frombits(v.bits.Load())
+}
+
+func (v *value) store(x float64) {
+	v.bits.Store(math.Float64bits(x))
+}
+
+func (v *value) add(d float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+d)) {
+			return
+		}
+	}
+}
+
+// Counter puo' solo crescere.
+type Counter struct{ v value }
+
+func (c *Counter) Inc() { c.v.add(1) }
+
+// Add ignora i valori negativi.
+func (c *Counter) Add(d float64) {
+	if d > 0 {
+		c.v.add(d)
+	}
+}
+
+func (c *Counter) Value() float64 { return c.v.load() }
+
+type Gauge struct{ v value }
+
+func (g *Gauge) Set(x float64) { g.v.store(x) }
+func (g *Gauge) Add(d float64) { g.v.add(d) }
+func (g *Gauge) Inc()          { g.v.add(1) }
+func (g *Gauge) Dec()          { g.v.add(-1) }
+
+// SetBool imposta 1 o 0.
+func (g *Gauge) SetBool(b bool) {
+	if b {
+		g.Set(1)
+	} else {
+		g.Set(0)
+	}
+}
+
+func (g *Gauge) Value() float64 { return g.v.load() }
+
+type series struct {
+	labelValues []string
+	counter     *Counter
+	gauge       *Gauge
+}
+
+func (s *series) get() float64 {
+	if s.counter != nil {
+		return s.counter.Value()
+	}
+	return s.gauge.Value()
+}
+
+// family raggruppa le serie di una metrica, una per combinazione di etichette.
+type family struct {
+	name   string
+	help   string
+	kind   string // "counter" o "gauge"
+	labels []string
+	fn     func() float64 // per le metriche calcolate al momento della lettura
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s richiede %d etichette, ricevute %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		if f.kind == "counter" {
+			s.counter = &Counter{}
+		} else {
+			s.gauge = &Gauge{}
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+type CounterVec struct{ f *family }
+
+// With restituisce il contatore per i valori delle etichette, nell'ordine della dichiarazione.
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return c.f.get(labelValues).counter
+}
+
+type GaugeVec struct{ f *family }
+
+func (g *GaugeVec) With(labelValues ...string) *Gauge {
+	return g.f.get(labelValues).gauge
+}
+
+// Registry contiene le metriche esposte; Default e' quello usato dai costruttori di package.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+var Default = NewRegistry()
+
+func (r *Registry) register(f *family) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[f.name]; ok {
+		panic("metrics: metrica registrata due volte: " + f.name)
+	}
+	f.series = make(map[string]*series)
+	r.families[f.name] = f
+	return f
+}
+
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(&family{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(&family{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+// NewGaugeFunc registra una gauge il cui valore viene calcolato da fn ad ogni lettura.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&family{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+func NewCounter(name, help string) *Counter { return Default.NewCounter(name, help) }
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+func NewGauge(name, help string) *Gauge { return Default.NewGauge(name, help) }
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) { Default.NewGaugeFunc(name, help, fn) }
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WriteTo scrive tutte le metriche nel formato testuale di Prometheus (versione 0.0.4),
+// in ordine di nome e di etichette.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(families, func(a, b *family) int { return strings.Compare(a.name, b.name) })
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", f.name, helpEscaper.Replace(f.help), f.name, f.kind)
+		if f.fn != nil {
+			fmt.Fprintf(bw, "%s %s\n", f.name, formatValue(f.fn()))
+			continue
+		}
+		f.mu.Lock()
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			bw.WriteString(f.name)
+			if len(f.labels) > 0 {
+				bw.WriteByte('{')
+				for i, label := range f.labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, `%s="%s"`, label, labelEscaper.Replace(s.labelValues[i]))
+				}
+				bw.WriteByte('}')
+			}
+			fmt.Fprintf(bw, " %s\n", formatValue(s.get()))
+		}
+		f.mu.Unlock()
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Handler espone il registry di default.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.WriteTo(w)
+	})
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestWriteToTextFormat(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("http_requests_total", "Richieste HTTP\ngestite", "route", "code")
+	requests.With("/api/me", "200").Add(3)
+	requests.With("/api/alarms", "200").Inc()
+	requests.With("/api/alarms", "200").Add(-5) // ignorato: un contatore non decresce
+	r.NewGauge("window_position", `Posizione \ della finestra`).Set(45.5)
+	r.NewGaugeFunc("min_temperature", "Temperatura minima", func() float64 { return math.Inf(1) })
+	r.NewGaugeFunc("fused_temperature", "Temperatura combinata", math.NaN)
+
+	var b strings.Builder
+	n, err := r.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP fused_temperature Temperatura combinata
+# TYPE fused_temperature gauge
+fused_temperature NaN
+# HELP http_requests_total Richieste HTTP\ngestite
+# TYPE http_requests_total counter
+http_requests_total{route="/api/alarms",code="200"} 1
+http_requests_total{route="/api/me",code="200"} 3
+# HELP min_temperature Temperatura minima
+# TYPE min_temperature gauge
+min_temperature +Inf
+# HELP window_position Posizione \\ della finestra
+# TYPE window_position gauge
+window_position 45.5
+`
+	if b.String() != want {
+		t.Fatalf("esposizione\n%s\nattesa\n%s", b.String(), want)
+	}
+	if n != int64(len(want)) {
+		t.Fatalf("%d byte scritti, restituiti %d", len(want), n)
+	}
+}
+
+func TestWriteToEscapesLabelValues(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeVec("sensor_fault", "Sensori in fault", "sensor", "reason").
+		With(`C:\sonda`, "valore \"fuori scala\"\nda 3 letture").Set(1)
+
+	var b strings.Builder
+	r.WriteTo(&b)
+	line := `sensor_fault{sensor="C:\\sonda",reason="valore \"fuori scala\"\nda 3 letture"} 1`
+	if !strings.Contains(b.String(), line+"\n") {
+		t.Fatalf("serie non trovata in\n%s", b.String())
+	}
+}
+
+func TestRegistryRejectsDuplicatesAndWrongLabels(t *testing.T) {
+	r := NewRegistry()
+	vec := r.NewCounterVec("alarms_total", "Allarmi", "zone")
+	mustPanic(t, "metrica registrata due volte", func() { r.NewGauge("alarms_total", "Allarmi") })
+	mustPanic(t, "numero di etichette errato", func() { vec.With("serra", "critical") })
+}
+
+func mustPanic(t *testing.T, msg string, fn func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("nessun panic: %s", msg)
+		}
+	}()
+	fn()
+}
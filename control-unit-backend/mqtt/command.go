@@ -0,0 +1,154 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"server/bus"
+	"server/system"
+	"strings"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	CommandTopic         = "control/cmd"
+	CommandResponseTopic = "control/cmd/response"
+
+	commandTimeout     = 5 * time.Second
+	commandResponseTTL = 30 * time.Second
+)
+
+// Schema JSON dei comandi, ad esempio:
+//
+//	{"id":"b7c1","command":"open-window"}
+//	{"id":"b7c2","command":"set-window-position","position":45}
+//	{"id":"b7c3","command":"set-mode","mode":"manual"}
+//	{"id":"b7c4","zone":"camera","command":"reset-alarm"}
+//
+// Senza "zone" il comando e' destinato alla zona di default.
+// command accetta i nomi di system.RequestType sia in forma "OpenWindow" sia "open-window".
+type commandPayload struct {
+	ID       string `json:"id"`
+	Zone     string `json:"zone"`
+	Command  string `json:"command"`
+	Position *int   `json:"position"`
+	Mode     string `json:"mode"`
+}
+
+type CommandResponse struct {
+	ID       string    `json:"id"`
+	Zone     string    `json:"zone,omitempty"`
+	Command  string    `json:"command"`
+	Accepted bool      `json:"accepted"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+var requestTypes = map[string]system.RequestType{
+	"togglemode":        system.ToggleMode,
+	"openwindow":        system.OpenWindow,
+	"closewindow":       system.CloseWindow,
+	"resetalarm":        system.ResetAlarm,
+	"setwindowposition": system.SetWindowPosition,
+	"setmode":           system.SetMode,
+}
+
+var operativeModes = map[string]system.OperativeMode{
+	"manual":                                system.Manual,
+	"automatic":                             system.Automatic,
+	strings.ToLower(system.Manual.String()): system.Manual,
+	strings.ToLower(system.Automatic.String()): system.Automatic,
+}
+
+func ParseRequestType(name string) (system.RequestType, error) {
+	key := strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(name))
+	requestType, ok := requestTypes[key]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", system.ErrUnknownCommand, name)
+	}
+	return requestType, nil
+}
+
+// CommandMessageHandler inoltra i comandi ricevuti su CommandTopic allo stesso topic usato dalle API
+// e pubblica l'esito su CommandResponseTopic. L'attesa della risposta avviene fuori dalla callback
+// per non bloccare la ricezione degli altri messaggi.
+// zoneCommands restituisce il topic dei comandi della zona; "" indica la zona di default.
+func CommandMessageHandler(zoneCommands func(zone string) (*bus.Topic[system.CommandRequest], bool), outbox *Outbox) MQTT.MessageHandler {
+	return func(client MQTT.Client, msg MQTT.Message) {
+		MessagesReceived.With("command").Inc()
+		var p commandPayload
+		if err := json.Unmarshal(msg.Payload(), &p); err != nil {
+			ParseErrors.With("command").Inc()
+			logger.Warn("invalid command payload", "topic", msg.Topic(), "error", err)
+			publishCommandResponse(outbox, CommandResponse{Error: "payload non valido: " + err.Error()})
+			return
+		}
+		response := CommandResponse{ID: p.ID, Zone: p.Zone, Command: p.Command}
+
+		commands, ok := zoneCommands(p.Zone)
+		if !ok {
+			response.Error = fmt.Sprintf("zona sconosciuta: %q", p.Zone)
+			publishCommandResponse(outbox, response)
+			return
+		}
+
+		requestType, err := ParseRequestType(p.Command)
+		if err != nil {
+			response.Error = err.Error()
+			publishCommandResponse(outbox, response)
+			return
+		}
+
+		request := system.NewCommandRequest(p.ID, requestType, system.SourceMQTT)
+		switch requestType {
+		case system.SetWindowPosition:
+			if p.Position == nil {
+				response.Error = "campo position mancante"
+				publishCommandResponse(outbox, response)
+				return
+			}
+			request.Position = system.Degree(*p.Position)
+		case system.SetMode:
+			mode, ok := operativeModes[strings.ToLower(p.Mode)]
+			if !ok {
+				response.Error = fmt.Sprintf("%v: %q", system.ErrInvalidMode, p.Mode)
+				publishCommandResponse(outbox, response)
+				return
+			}
+			request.Mode = mode
+		}
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+			err := commands.PublishContext(ctx, request)
+			cancel()
+			if err != nil {
+				response.Error = "timeout invio comando"
+				publishCommandResponse(outbox, response)
+				return
+			}
+			select {
+			case err := <-request.Reply:
+				response.Accepted = err == nil
+				if err != nil {
+					response.Error = err.Error()
+				}
+			case <-time.After(commandTimeout):
+				response.Error = "timeout risposta comando"
+			}
+			publishCommandResponse(outbox, response)
+		}()
+	}
+}
+
+func publishCommandResponse(outbox *Outbox, response CommandResponse) {
+	response.Time = time.Now()
+	payload, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("command response serialization failed", "error", err)
+		return
+	}
+	outbox.Publish(CommandResponseTopic, false, payload, "", commandResponseTTL)
+}
@@ -0,0 +1,151 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"server/system"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	HomeAssistantDiscoveryPrefix = "homeassistant"
+	HomeAssistantStatusTopic     = HomeAssistantDiscoveryPrefix + "/status"
+
+	haNodeID = "iot_control_unit"
+)
+
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+type haEntity struct {
+	component string
+	objectID  string
+	config    map[string]any
+}
+
+// ogni zona compare in Home Assistant come un dispositivo separato
+func haNode(zone ZoneTopics) (string, haDevice) {
+	nodeID := haNodeID
+	name := "Smart Temperature Control Unit"
+	if !zone.IsDefault {
+		nodeID += "_" + zone.Zone
+		name += " - " + zone.Name
+	}
+	return nodeID, haDevice{
+		Identifiers:  []string{nodeID},
+		Name:         name,
+		Manufacturer: "IOT-Project3",
+		Model:        "control-unit-backend",
+	}
+}
+
+// haCommand costruisce il payload JSON di un comando per la zona; extra viene inserito cosi' com'e'
+func haCommand(zone ZoneTopics, command, extra string) string {
+	payload := `{"command":"` + command + `"`
+	if !zone.IsDefault {
+		payload += `,"zone":"` + zone.Zone + `"`
+	}
+	if extra != "" {
+		payload += "," + extra
+	}
+	return payload + "}"
+}
+
+// le entita' leggono lo stato dal topic retained della zona e inviano i comandi
+// sullo stesso topic JSON usato da CommandMessageHandler
+func haEntities(zone ZoneTopics) []haEntity {
+	return []haEntity{
+		{"sensor", "temperature", map[string]any{
+			"name":                "Temperatura",
+			"state_topic":         zone.State,
+			"value_template":      "{{ value_json.CurrentTemp }}",
+			"unit_of_measurement": "°C",
+			"device_class":        "temperature",
+			"state_class":         "measurement",
+		}},
+		{"sensor", "status", map[string]any{
+			"name":           "Stato sistema",
+			"state_topic":    zone.State,
+			"value_template": "{{ value_json.StatusString }}",
+			"device_class":   "enum",
+			"options": []string{
+				system.Normal.String(), system.Hot.String(), system.Too_hot.String(), system.Alarm.String(),
+			},
+		}},
+		{"cover", "window", map[string]any{
+			"name":                  "Finestra",
+			"device_class":          "window",
+			"command_topic":         CommandTopic,
+			"payload_open":          haCommand(zone, "open-window", ""),
+			"payload_close":         haCommand(zone, "close-window", ""),
+			"payload_stop":          nil,
+			"position_topic":        zone.State,
+			"position_template":     "{{ value_json.WindowPosition }}",
+			"position_open":         90,
+			"position_closed":       0,
+			"set_position_topic":    CommandTopic,
+			"set_position_template": haCommand(zone, "set-window-position", `"position":{{ position }}`),
+		}},
+		{"select", "operative_mode", map[string]any{
+			"name":             "Modalità operativa",
+			"state_topic":      zone.State,
+			"value_template":   "{{ value_json.OperativeModeString }}",
+			"command_topic":    CommandTopic,
+			"command_template": haCommand(zone, "set-mode", `"mode":"{{ value }}"`),
+			"options":          []string{system.Manual.String(), system.Automatic.String()},
+		}},
+		{"button", "reset_alarm", map[string]any{
+			"name":          "Reset allarme",
+			"command_topic": CommandTopic,
+			"payload_press": haCommand(zone, "reset-alarm", ""),
+		}},
+	}
+}
+
+// PublishHomeAssistantDiscovery pubblica (retained) i messaggi di discovery di Home Assistant per ogni zona.
+func PublishHomeAssistantDiscovery(client MQTT.Client, zones []ZoneTopics) {
+	for _, zone := range zones {
+		publishZoneDiscovery(client, zone)
+	}
+	logger.Info("home assistant discovery published", "zones", len(zones))
+}
+
+func publishZoneDiscovery(client MQTT.Client, zone ZoneTopics) {
+	nodeID, device := haNode(zone)
+	for _, entity := range haEntities(zone) {
+		entity.config["unique_id"] = nodeID + "_" + entity.objectID
+		entity.config["object_id"] = nodeID + "_" + entity.objectID
+		entity.config["device"] = device
+		entity.config["availability_topic"] = StatusTopic
+		entity.config["payload_available"] = StatusOnline
+		entity.config["payload_not_available"] = StatusOffline
+
+		payload, err := json.Marshal(entity.config)
+		if err != nil {
+			logger.Error("discovery serialization failed", "entity", entity.objectID, "error", err)
+			continue
+		}
+		topic := HomeAssistantDiscoveryPrefix + "/" + entity.component + "/" + nodeID + "/" + entity.objectID + "/config"
+		publish(client, topic, true, payload)
+	}
+}
+
+// HomeAssistantOnConnect pubblica la discovery alla connessione e la ripubblica
+// ogni volta che Home Assistant segnala di essere tornato online.
+func HomeAssistantOnConnect(zones []ZoneTopics) func(MQTT.Client) {
+	return func(c MQTT.Client) {
+		PublishHomeAssistantDiscovery(c, zones)
+		handler := func(client MQTT.Client, msg MQTT.Message) {
+			if string(msg.Payload()) == StatusOnline {
+				go PublishHomeAssistantDiscovery(client, zones)
+			}
+		}
+		if token := c.Subscribe(HomeAssistantStatusTopic, 1, handler); token.Wait() && token.Error() != nil {
+			logger.Error("subscribe failed", "topic", HomeAssistantStatusTopic, "error", token.Error())
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package mqtt
+
+import "server/metrics"
+
+var (
+	// MessagesReceived e ParseErrors sono indicizzati per tipo di messaggio ("temperature", "command").
+	MessagesReceived = metrics.NewCounterVec("control_unit_mqtt_messages_received_total",
+		"Messaggi MQTT ricevuti.", "kind")
+	ParseErrors = metrics.NewCounterVec("control_unit_mqtt_parse_errors_total",
+		"Messaggi MQTT ricevuti non validi.", "kind")
+
+	messagesPublished = metrics.NewCounter("control_unit_mqtt_messages_published_total",
+		"Messaggi MQTT consegnati al broker dalla coda in uscita.")
+	publishErrors = metrics.NewCounter("control_unit_mqtt_publish_errors_total",
+		"Tentativi di pubblicazione falliti o scaduti.")
+)
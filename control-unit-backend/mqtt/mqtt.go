@@ -2,61 +2,131 @@ package mqtt
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"server/logging"
 	"strconv"
 	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 )
 
-func MqttPublishInterval(ctx context.Context, client MQTT.Client, IntervalUpdatesChan <-chan time.Duration) {
-	const configTopic = "esp32/config/interval"
-	log.Println("INFO: Publisher MQTT avviato.")
+var logger = logging.For("mqtt")
+
+// Opzioni di connessione al broker. Il TLS viene abilitato se e' specificato un CA bundle
+// o un certificato client, oppure se il broker usa lo schema ssl://, tls:// o mqtts://.
+type ClientOptions struct {
+	Broker             string
+	ClientID           string
+	Username           string
+	Password           string
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+	CleanSession       bool
+	KeepAlive          time.Duration
+	ConnectTimeout     time.Duration
+}
+
+func (o ClientOptions) TLSConfig() (*tls.Config, error) {
+	if o.CAFile == "" && o.CertFile == "" && o.KeyFile == "" && !o.InsecureSkipVerify && o.ServerName == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("errore lettura CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("nessun certificato valido in %s", o.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, fmt.Errorf("certificato e chiave client devono essere specificati entrambi")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("errore caricamento certificato client: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func MqttPublishInterval(ctx context.Context, outbox *Outbox, configTopic string, IntervalUpdatesChan <-chan time.Duration) {
+	logger.Info("interval publisher started", "topic", configTopic)
 
 	for {
 		select {
 		case interval := <-IntervalUpdatesChan:
 			intervalPayload := strconv.FormatInt(interval.Milliseconds(), 10)
-			token := client.Publish(configTopic, 1, false, intervalPayload)
-			token.Wait()
+			// conta solo l'ultimo intervallo: i valori non ancora inviati vengono sostituiti
+			outbox.Publish(configTopic, false, []byte(intervalPayload), configTopic, 0)
 		case <-ctx.Done():
-			log.Println("MQTT Publish: Shutdown")
+			logger.Info("interval publisher stopped")
 			return
 		}
 	}
 }
 
-func ConfigureClient(broker string, clientID string, onConnectCallbacks ...func(MQTT.Client)) (MQTT.Client, error) {
+func ConfigureClient(options ClientOptions, onConnectCallbacks ...func(MQTT.Client)) (MQTT.Client, error) {
+	tlsConfig, err := options.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	opts := MQTT.NewClientOptions()
-	opts.AddBroker(broker)
-	opts.SetClientID(clientID)
+	opts.AddBroker(options.Broker)
+	opts.SetClientID(options.ClientID)
+	opts.SetUsername(options.Username)
+	opts.SetPassword(options.Password)
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
 	opts.SetAutoReconnect(true)
 	opts.SetConnectRetry(true)
 	opts.SetConnectRetryInterval(500 * time.Millisecond)
-	opts.SetKeepAlive(3 * time.Second)
+	opts.SetKeepAlive(options.KeepAlive)
 	opts.SetPingTimeout(1 * time.Second)
-	opts.SetCleanSession(true)
+	opts.SetConnectTimeout(options.ConnectTimeout)
+	opts.SetCleanSession(options.CleanSession)
+	opts.SetWill(StatusTopic, StatusOffline, 1, true)
 
 	// Log di connessione/disconnessione
 	opts.OnConnect = func(c MQTT.Client) {
-		log.Println("MQTT: connesso al broker.")
+		logger.Info("connected to broker", "broker", options.Broker)
+		publish(c, StatusTopic, true, StatusOnline)
 		for _, callback := range onConnectCallbacks {
 			callback(c)
 		}
 	}
 	opts.OnConnectionLost = func(c MQTT.Client, err error) {
-		log.Printf("MQTT: connessione persa: %v", err)
+		logger.Warn("connection lost", "error", err)
 	}
 	opts.OnReconnecting = func(c MQTT.Client, opts *MQTT.ClientOptions) {
-		log.Println("MQTT: tentativo di riconnessione...")
+		logger.Info("reconnecting")
 	}
-	log.Println("Connessione al Broker MQTT in corso")
+	logger.Info("connecting to broker", "broker", options.Broker, "client_id", options.ClientID)
 	client := MQTT.NewClient(opts)
+	// la connessione viene ritentata in background: il resto del server parte comunque
+	// e lo stato del broker e' visibile da IsConnected e da /readyz
 	token := client.Connect()
-
-	if token.Wait() && token.Error() != nil {
-		return nil, token.Error()
-	}
-	log.Println("Connessione al Broker MQTT avvenuta")
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			logger.Error("connection failed", "broker", options.Broker, "error", token.Error())
+		}
+	}()
 	return client, nil
 }
@@ -0,0 +1,335 @@
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"server/system"
+	"slices"
+	"sync"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	outboxRetryInterval = 500 * time.Millisecond
+	outboxMaxBackoff    = 10 * time.Second
+)
+
+// Messaggio in uscita. I messaggi con la stessa Key si sostituiscono a vicenda nella coda,
+// cosi' per esempio viene inviato solo l'ultimo intervallo di campionamento.
+type OutboundMessage struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retained bool
+	Key      string    `json:",omitempty"`
+	Expires  time.Time `json:",omitzero"` // zero: nessuna scadenza
+}
+
+type queuedMessage struct {
+	ID uint64
+	OutboundMessage
+}
+
+// Riga del giornale su disco: un messaggio accodato o l'ID di un messaggio uscito dalla coda
+// (inviato, sostituito, scaduto o scartato).
+type journalEntry struct {
+	Add    *queuedMessage `json:",omitempty"`
+	Remove uint64         `json:",omitempty"`
+}
+
+// Outbox e' una coda limitata di messaggi in uscita, svuotata da Run quando il broker e' raggiungibile.
+// Enqueue non blocca mai: a coda piena viene scartato il messaggio piu' vecchio.
+// Se path non e' vuoto ogni modifica viene aggiunta in fondo a un giornale su disco, riletto all'avvio;
+// il giornale viene riscritto con il solo contenuto della coda quando diventa troppo lungo.
+type Outbox struct {
+	mu         sync.Mutex
+	queue      []queuedMessage
+	nextID     uint64
+	capacity   int
+	path       string
+	journal    *os.File
+	journalLen int // righe scritte nel giornale dall'ultima compattazione
+	notify     chan struct{}
+	dropped    uint64
+	expired    uint64
+}
+
+func NewOutbox(capacity int, path string) (*Outbox, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("capacità della coda MQTT non valida: %d", capacity)
+	}
+	o := &Outbox{
+		capacity: capacity,
+		path:     path,
+		notify:   make(chan struct{}, 1),
+	}
+	if path == "" {
+		return o, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("errore creazione cartella coda MQTT: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("errore lettura coda MQTT: %w", err)
+	}
+	o.queue = replayJournal(path, data)
+	for _, m := range o.queue {
+		o.nextID = max(o.nextID, m.ID)
+	}
+	if len(o.queue) > capacity {
+		o.queue = o.queue[len(o.queue)-capacity:]
+	}
+	if err := o.compact(); err != nil {
+		return nil, fmt.Errorf("errore scrittura coda MQTT: %w", err)
+	}
+	if len(o.queue) > 0 {
+		logger.Info("outbox restored", "messages", len(o.queue))
+		o.signal()
+	}
+	return o, nil
+}
+
+// replayJournal ricostruisce la coda dal giornale. Una riga troncata da un arresto durante la
+// scrittura chiude il giornale.
+func replayJournal(path string, data []byte) []queuedMessage {
+	var queue []queuedMessage
+	for line := range bytes.Lines(data) {
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			logger.Warn("invalid outbox journal entry, rest ignored", "path", path, "error", err)
+			break
+		}
+		switch {
+		case entry.Add != nil:
+			queue = append(queue, *entry.Add)
+		case entry.Remove != 0:
+			queue = slices.DeleteFunc(queue, func(m queuedMessage) bool { return m.ID == entry.Remove })
+		}
+	}
+	return queue
+}
+
+func (o *Outbox) Enqueue(msg OutboundMessage) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var entries []journalEntry
+	if msg.Key != "" {
+		for i, queued := range o.queue {
+			if queued.Key == msg.Key {
+				o.queue = append(o.queue[:i], o.queue[i+1:]...)
+				entries = append(entries, journalEntry{Remove: queued.ID})
+				break
+			}
+		}
+	}
+	if len(o.queue) >= o.capacity {
+		entries = append(entries, journalEntry{Remove: o.queue[0].ID})
+		o.queue = o.queue[1:]
+		o.dropped++
+		system.DroppedEntries.With("", "mqtt_outbox").Inc()
+		logger.Warn("buffer full, dropped oldest value", "buffer", "outbox", "dropped_total", o.dropped)
+	}
+	o.nextID++
+	queued := queuedMessage{ID: o.nextID, OutboundMessage: msg}
+	o.queue = append(o.queue, queued)
+	o.persist(append(entries, journalEntry{Add: &queued})...)
+	o.signal()
+}
+
+// Publish accoda un messaggio con una scadenza relativa; ttl <= 0 significa nessuna scadenza.
+func (o *Outbox) Publish(topic string, retained bool, payload []byte, key string, ttl time.Duration) {
+	msg := OutboundMessage{Topic: topic, Payload: payload, QoS: 1, Retained: retained, Key: key}
+	if ttl > 0 {
+		msg.Expires = time.Now().Add(ttl)
+	}
+	o.Enqueue(msg)
+}
+
+// Stats restituisce il numero di messaggi in coda e quelli scartati perche' la coda era piena o scaduti.
+func (o *Outbox) Stats() (queued int, dropped, expired uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.queue), o.dropped, o.expired
+}
+
+func (o *Outbox) signal() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+// peek restituisce il primo messaggio non scaduto, eliminando quelli scaduti.
+func (o *Outbox) peek(now time.Time) (queuedMessage, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var entries []journalEntry
+	for len(o.queue) > 0 && !o.queue[0].Expires.IsZero() && now.After(o.queue[0].Expires) {
+		entries = append(entries, journalEntry{Remove: o.queue[0].ID})
+		o.queue = o.queue[1:]
+	}
+	if len(entries) > 0 {
+		o.expired += uint64(len(entries))
+		o.persist(entries...)
+	}
+	if len(o.queue) == 0 {
+		return queuedMessage{}, false
+	}
+	return o.queue[0], true
+}
+
+func (o *Outbox) remove(id uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, queued := range o.queue {
+		if queued.ID == id {
+			o.queue = append(o.queue[:i], o.queue[i+1:]...)
+			o.persist(journalEntry{Remove: id})
+			return
+		}
+	}
+}
+
+// persist aggiunge le modifiche in fondo al giornale, che viene svuotato quando lo e' la coda
+// e riscritto quando supera alcune volte la capacita'. Va chiamata con il lock acquisito.
+func (o *Outbox) persist(entries ...journalEntry) {
+	if o.path == "" {
+		return
+	}
+	if len(o.queue) == 0 {
+		// coda vuota: basta svuotare il giornale
+		if err := o.journal.Truncate(0); err != nil {
+			logger.Error("outbox save failed", "path", o.path, "error", err)
+			return
+		}
+		o.journalLen = 0
+		return
+	}
+	if o.journalLen+len(entries) > 4*o.capacity {
+		if err := o.compact(); err != nil {
+			logger.Error("outbox save failed", "path", o.path, "error", err)
+		}
+		return
+	}
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			logger.Error("outbox serialization failed", "error", err)
+			return
+		}
+		buf.Write(append(line, '\n'))
+	}
+	if _, err := o.journal.Write(buf.Bytes()); err != nil {
+		logger.Error("outbox save failed", "path", o.path, "error", err)
+		return
+	}
+	o.journalLen += len(entries)
+}
+
+// compact riscrive il giornale con il solo contenuto attuale della coda, con scrittura atomica,
+// e lo riapre in aggiunta.
+func (o *Outbox) compact() error {
+	var buf bytes.Buffer
+	for i := range o.queue {
+		line, err := json.Marshal(journalEntry{Add: &o.queue[i]})
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(o.path), filepath.Base(o.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), o.path); err != nil {
+		return err
+	}
+	journal, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if o.journal != nil {
+		o.journal.Close()
+	}
+	o.journal, o.journalLen = journal, len(o.queue)
+	return nil
+}
+
+// Run invia i messaggi in coda uno alla volta, in ordine, finche' il context non viene cancellato.
+// Se il broker non e' raggiungibile i messaggi restano in coda e l'invio viene ritentato con backoff.
+func (o *Outbox) Run(ctx context.Context, client MQTT.Client) {
+	logger.Info("outbox started")
+	backoff := outboxRetryInterval
+
+	wait := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		}
+	}
+
+	for {
+		msg, ok := o.peek(time.Now())
+		if !ok {
+			select {
+			case <-ctx.Done():
+				logger.Info("outbox stopped")
+				return
+			case <-o.notify:
+				continue
+			}
+		}
+
+		if !client.IsConnectionOpen() {
+			if !wait(outboxRetryInterval) {
+				logger.Info("outbox stopped")
+				return
+			}
+			continue
+		}
+
+		token := client.Publish(msg.Topic, msg.QoS, msg.Retained, msg.Payload)
+		if token.WaitTimeout(publishTimeout) && token.Error() == nil {
+			o.remove(msg.ID)
+			messagesPublished.Inc()
+			backoff = outboxRetryInterval
+			continue
+		}
+		publishErrors.Inc()
+		if token.Error() != nil {
+			logger.Warn("publish failed", "topic", msg.Topic, "error", token.Error(), "retry_in", backoff)
+		} else {
+			logger.Warn("publish timeout", "topic", msg.Topic, "retry_in", backoff)
+		}
+		if !wait(backoff) {
+			logger.Info("outbox stopped")
+			return
+		}
+		backoff = min(backoff*2, outboxMaxBackoff)
+	}
+}
@@ -0,0 +1,210 @@
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"server/system"
+	"slices"
+	"testing"
+	"time"
+)
+
+func queuedTopics(o *Outbox) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var topics []string
+	for _, m := range o.queue {
+		topics = append(topics, m.Topic+"="+string(m.Payload))
+	}
+	return topics
+}
+
+func TestOutboxRestoresJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	o, err := NewOutbox(3, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Publish("a", false, []byte("1"), "", 0)
+	o.Publish("state", true, []byte("vecchio"), "state", 0)
+	o.Publish("b", false, []byte("2"), "", 0)
+	o.Publish("state", true, []byte("nuovo"), "state", 0) // sostituisce il precedente
+	o.Publish("c", false, []byte("3"), "", 0)             // coda piena: esce "a"
+	first, _ := o.peek(time.Now())
+	o.remove(first.ID)
+
+	want := []string{"state=nuovo", "c=3"}
+	if got := queuedTopics(o); !slices.Equal(got, want) {
+		t.Fatalf("coda %v, attesa %v", got, want)
+	}
+	restored, err := NewOutbox(3, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := queuedTopics(restored); !slices.Equal(got, want) {
+		t.Fatalf("coda ripristinata %v, attesa %v", got, want)
+	}
+	// i nuovi messaggi non riusano gli ID di quelli ripristinati
+	restored.Publish("d", false, []byte("4"), "", 0)
+	if restored.queue[2].ID <= restored.queue[1].ID {
+		t.Fatalf("ID %d riusato", restored.queue[2].ID)
+	}
+}
+
+func TestOutboxAppendsToJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	o, err := NewOutbox(100, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Publish("a", false, []byte("1"), "", 0)
+	before, _ := os.ReadFile(path)
+	o.Publish("b", false, []byte("2"), "", 0)
+	after, _ := os.ReadFile(path)
+	// il file precedente resta un prefisso: la nuova modifica e' solo aggiunta in fondo
+	if !bytes.HasPrefix(after, before) || bytes.Count(after, []byte("\n")) != 2 {
+		t.Fatalf("giornale riscritto:\n%s\n->\n%s", before, after)
+	}
+
+	for range 3 {
+		msg, _ := o.peek(time.Now())
+		o.remove(msg.ID)
+	}
+	if data, _ := os.ReadFile(path); len(data) != 0 {
+		t.Fatalf("giornale non svuotato con la coda vuota: %s", data)
+	}
+}
+
+func TestOutboxCompactsLongJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	o, err := NewOutbox(2, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// la stessa chiave sostituita molte volte: la coda resta di un messaggio
+	for i := range 50 {
+		o.Publish("state", true, []byte{byte('a' + i%26)}, "state", 0)
+	}
+	data, _ := os.ReadFile(path)
+	if lines := bytes.Count(data, []byte("\n")); lines > 4*2+2 {
+		t.Fatalf("giornale di %d righe per un solo messaggio in coda", lines)
+	}
+	restored, err := NewOutbox(2, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := queuedTopics(restored); !slices.Equal(got, queuedTopics(o)) {
+		t.Fatalf("coda ripristinata %v, attesa %v", got, queuedTopics(o))
+	}
+}
+
+func TestOutboxReadsTruncatedJournal(t *testing.T) {
+	// un arresto durante la scrittura lascia l'ultima riga a meta'
+	truncated := filepath.Join(t.TempDir(), "truncated.jsonl")
+	line, _ := json.Marshal(journalEntry{Add: &queuedMessage{ID: 1, OutboundMessage: OutboundMessage{Topic: "b", Payload: []byte("2")}}})
+	os.WriteFile(truncated, append(append(line, '\n'), line[:len(line)/2]...), 0o644)
+	o, err := NewOutbox(10, truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := queuedTopics(o); !slices.Equal(got, []string{"b=2"}) {
+		t.Fatalf("coda dal giornale troncato %v", got)
+	}
+}
+
+func enqueued(o *Outbox) uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.nextID
+}
+
+func TestStateNotRepublishedForVolatileFields(t *testing.T) {
+	outbox, err := NewOutbox(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make(chan system.SystemState)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		MqttPublishState(ctx, outbox, TopicsForZone("serra", "Serra", true), states, nil)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	now := time.Now()
+	state := func(temp float64, seen time.Time) system.SystemState {
+		return system.SystemState{
+			Zone:        "serra",
+			CurrentTemp: temp,
+			Sensors: map[string]system.SensorState{
+				"esp32": {Value: temp, LastSeen: seen, Online: true, Health: system.SensorHealth{Received: uint64(seen.Unix())}},
+			},
+			ManualLeaseRemaining: time.Until(seen),
+		}
+	}
+	states <- state(24, now)
+	states <- state(24, now.Add(time.Second))
+	states <- state(24, now.Add(2*time.Second))
+	states <- state(24.5, now.Add(3*time.Second))
+	states <- state(24.5, now.Add(4*time.Second)) // sincronizza con l'ultima pubblicazione
+
+	if n := enqueued(outbox); n != 2 {
+		t.Fatalf("stato pubblicato %d volte invece di 2", n)
+	}
+}
+
+func TestInitialStatePublishedBeforeFirstReading(t *testing.T) {
+	outbox, err := NewOutbox(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topics := TopicsForZone("serra", "Serra", true)
+	states := make(chan system.SystemState)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		MqttPublishState(ctx, outbox, topics, states, nil)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	retained := func() []byte {
+		outbox.mu.Lock()
+		defer outbox.mu.Unlock()
+		for _, m := range outbox.queue {
+			if m.Topic == topics.State {
+				return m.Payload
+			}
+		}
+		return nil
+	}
+
+	// stato con cui parte il system manager, prima di qualsiasi lettura
+	initial := system.SystemState{Zone: "serra", Status: system.Normal, OperativeMode: system.Automatic}
+	states <- initial
+	states <- initial // sincronizza con l'ultima pubblicazione
+	if payload := retained(); !bytes.Contains(payload, []byte(`"MinTemp":null`)) {
+		t.Fatalf("stato iniziale non pubblicato o con minimo non nullo: %s", payload)
+	}
+
+	withReading := initial.Clone()
+	system.ManageTemperature(21.5, nil, &withReading)
+	states <- withReading
+	states <- withReading
+	if n := enqueued(outbox); n != 2 {
+		t.Fatalf("stato pubblicato %d volte invece di 2", n)
+	}
+	if payload := retained(); !bytes.Contains(payload, []byte(`"MinTemp":21.5,`)) {
+		t.Errorf("minimo non aggiornato dopo la prima lettura: %s", payload)
+	}
+}
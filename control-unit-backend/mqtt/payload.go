@@ -0,0 +1,151 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"server/system"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Versione corrente dello schema JSON dei messaggi dei sensori.
+const SensorPayloadVersion = 1
+
+// Topic per i sensori aggiuntivi: sensors/<id>/temperature, eventualmente con il prefisso della zona
+const SensorTopicPattern = "sensors/+/temperature"
+
+// SensorIDFromTopic ricava l'ID del sensore da un topic [zones/<zona>/]sensors/<id>/temperature.
+func SensorIDFromTopic(topic, defaultID string) string {
+	parts := strings.Split(topic, "/")
+	n := len(parts)
+	if n >= 3 && parts[n-3] == "sensors" && parts[n-1] == "temperature" && parts[n-2] != "" {
+		return parts[n-2]
+	}
+	return defaultID
+}
+
+// Schema JSON v1 dei messaggi dei sensori, ad esempio:
+//
+//	{"v":1,"id":"esp32","temp":23.5,"unit":"C","ts":1718000000123,"seq":42,"boot":7,"battery":3.91,"rssi":-61}
+//
+// Solo "temp" e' obbligatorio. "ts" puo' essere in secondi o millisecondi Unix
+// oppure una stringa RFC3339. "boot" e' un contatore degli avvii del sensore: quando cambia
+// la sequenza riparte senza che le letture vengano considerate fuori ordine.
+type sensorPayload struct {
+	Version int             `json:"v"`
+	ID      string          `json:"id"`
+	Temp    *float64        `json:"temp"`
+	Unit    string          `json:"unit"`
+	TS      json.RawMessage `json:"ts"`
+	Seq     *uint64         `json:"seq"`
+	Boot    *uint64         `json:"boot"`
+	Battery *float64        `json:"battery"`
+	RSSI    *int            `json:"rssi"`
+}
+
+// ParseTemperaturePayload interpreta sia il vecchio formato (solo il numero in chiaro)
+// sia lo schema JSON versionato. defaultID viene usato se il payload non specifica il sensore.
+func ParseTemperaturePayload(payload []byte, defaultID string, receivedAt time.Time) (system.TemperatureReading, error) {
+	reading := system.TemperatureReading{
+		SensorID:   defaultID,
+		Unit:       "C",
+		ReceivedAt: receivedAt,
+	}
+
+	trimmed := bytes.TrimSpace(payload)
+	if len(trimmed) == 0 {
+		return reading, fmt.Errorf("payload vuoto")
+	}
+
+	if trimmed[0] != '{' {
+		value, err := strconv.ParseFloat(string(trimmed), 64)
+		if err != nil {
+			return reading, fmt.Errorf("payload numerico non valido: %w", err)
+		}
+		reading.Value = value
+		return reading, nil
+	}
+
+	var p sensorPayload
+	if err := json.Unmarshal(trimmed, &p); err != nil {
+		return reading, fmt.Errorf("payload JSON non valido: %w", err)
+	}
+	if p.Version > SensorPayloadVersion {
+		return reading, fmt.Errorf("versione payload non supportata: %d", p.Version)
+	}
+	if p.Temp == nil {
+		return reading, fmt.Errorf("campo temp mancante")
+	}
+
+	value, err := toCelsius(*p.Temp, p.Unit)
+	if err != nil {
+		return reading, err
+	}
+	reading.Value = value
+
+	if p.ID != "" {
+		reading.SensorID = p.ID
+	}
+	if p.Seq != nil {
+		reading.Seq = *p.Seq
+		reading.HasSeq = true
+	}
+	if p.Boot != nil {
+		reading.Boot = *p.Boot
+		reading.HasBoot = true
+	}
+	reading.Battery = p.Battery
+	reading.RSSI = p.RSSI
+
+	if len(p.TS) > 0 {
+		ts, err := parseTimestamp(p.TS)
+		if err != nil {
+			return reading, err
+		}
+		reading.Timestamp = ts
+	}
+	return reading, nil
+}
+
+func toCelsius(value float64, unit string) (float64, error) {
+	switch strings.ToUpper(unit) {
+	case "", "C", "°C", "CELSIUS":
+		return value, nil
+	case "F", "°F", "FAHRENHEIT":
+		return (value - 32) * 5 / 9, nil
+	case "K", "KELVIN":
+		return value - 273.15, nil
+	default:
+		return 0, fmt.Errorf("unita' di misura non supportata: %q", unit)
+	}
+}
+
+// i timestamp numerici sotto questa soglia sono interpretati come secondi
+const maxUnixSeconds = 1e11
+
+func parseTimestamp(raw json.RawMessage) (time.Time, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		ts, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("timestamp non valido: %w", err)
+		}
+		return ts, nil
+	}
+
+	var n float64
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return time.Time{}, fmt.Errorf("timestamp non valido: %s", raw)
+	}
+	if n <= 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+		return time.Time{}, fmt.Errorf("timestamp non valido: %s", raw)
+	}
+	if n < maxUnixSeconds {
+		sec, frac := math.Modf(n)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	return time.UnixMilli(int64(n)), nil
+}
@@ -0,0 +1,123 @@
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"server/system"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	StateTopic         = "control-unit/state"
+	EventsTopic        = "control-unit/events"
+	StatusTopic        = "control-unit/status"
+	DevicesTopicPrefix = "control-unit/devices/"
+
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+
+	publishTimeout = 2 * time.Second
+	eventTTL       = 10 * time.Minute
+	// intervallo minimo tra due pubblicazioni dello stato che differiscono solo per i campi volatili
+	stateRefreshInterval = time.Minute
+)
+
+// MqttPublishState pubblica lo stato del sistema (retained) quando cambia,
+// gli eventi di transizione e lo stato online/offline di ogni dispositivo.
+// Le variazioni dei soli campi volatili (vedi stableState) vengono pubblicate al piu' ogni
+// stateRefreshInterval, per non riscrivere il messaggio retained ad ogni lettura.
+// I messaggi passano dalla outbox, quindi un broker irraggiungibile non blocca questa goroutine.
+func MqttPublishState(ctx context.Context, outbox *Outbox, topics ZoneTopics, stateUpdates <-chan system.SystemState, events <-chan system.Event) {
+	var lastState, lastStable []byte
+	var lastPublished time.Time
+	lastDevices := map[system.DeviceName]bool{}
+
+	for {
+		select {
+		case state := <-stateUpdates:
+			payload, err := json.Marshal(state)
+			if err != nil {
+				logger.Error("state serialization failed", "zone", topics.Zone, "error", err)
+				continue
+			}
+			stable, err := json.Marshal(stableState(state))
+			if err != nil {
+				logger.Error("state serialization failed", "zone", topics.Zone, "error", err)
+				continue
+			}
+			refresh := !bytes.Equal(payload, lastState) && time.Since(lastPublished) >= stateRefreshInterval
+			if !bytes.Equal(stable, lastStable) || refresh {
+				outbox.Publish(topics.State, true, payload, topics.State, 0)
+				lastState, lastStable, lastPublished = payload, stable, time.Now()
+			}
+			for device, online := range state.DevicesOnline {
+				if known, ok := lastDevices[device]; ok && known == online {
+					continue
+				}
+				topic := topics.DevicesPrefix + string(device)
+				outbox.Publish(topic, true, []byte(onlineString(online)), topic, 0)
+				lastDevices[device] = online
+			}
+
+		case event := <-events:
+			payload, err := json.Marshal(event)
+			if err != nil {
+				logger.Error("event serialization failed", "zone", topics.Zone, "error", err)
+				continue
+			}
+			outbox.Publish(EventsTopic, false, payload, "", eventTTL)
+
+		case <-ctx.Done():
+			logger.Info("state publisher stopped", "zone", topics.Zone)
+			return
+		}
+	}
+}
+
+// stableState azzera i campi che cambiano ad ogni lettura o ad ogni ciclo senza che lo stato
+// della zona cambi davvero: istante e contatori delle letture di ogni sensore e tempo rimanente
+// della modalita' manuale, che resta ricavabile dalla scadenza.
+func stableState(state system.SystemState) system.SystemState {
+	sensors := make(map[string]system.SensorState, len(state.Sensors))
+	for id, sensor := range state.Sensors {
+		sensor.LastSeen = time.Time{}
+		sensor.Health = system.SensorHealth{}
+		sensors[id] = sensor
+	}
+	state.Sensors = sensors
+	state.ManualLeaseRemaining = 0
+	return state
+}
+
+func publish(client MQTT.Client, topic string, retained bool, payload any) bool {
+	token := client.Publish(topic, 1, retained, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		logger.Warn("publish timeout", "topic", topic)
+		return false
+	}
+	if token.Error() != nil {
+		logger.Warn("publish failed", "topic", topic, "error", token.Error())
+		return false
+	}
+	return true
+}
+
+func onlineString(online bool) string {
+	if online {
+		return StatusOnline
+	}
+	return StatusOffline
+}
+
+// Disconnect segnala la disconnessione volontaria sul topic di stato prima di chiudere la connessione,
+// dato che il last will viene inviato dal broker solo in caso di disconnessione inattesa.
+func Disconnect(client MQTT.Client) {
+	if client.IsConnectionOpen() {
+		publish(client, StatusTopic, true, StatusOffline)
+	}
+	client.Disconnect(250)
+	logger.Info("disconnected from broker")
+}
@@ -0,0 +1,124 @@
+package mqtt
+
+import (
+	"crypto/x509"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+const connectWait = 5 * time.Second
+
+// clientOptions prepara le opzioni di un client che si autentica con un certificato di clientCA
+// e si fida dei server firmati da trustedCA.
+func clientOptions(t *testing.T, broker *fakeBroker, trustedCA, clientCA *testCA) ClientOptions {
+	t.Helper()
+	certPEM, keyPEM := clientCA.issue(t, "control-unit", x509.ExtKeyUsageClientAuth)
+	return ClientOptions{
+		Broker:         broker.URL(),
+		ClientID:       t.Name(),
+		CAFile:         writeFile(t, "ca.crt", trustedCA.pem),
+		CertFile:       writeFile(t, "client.crt", certPEM),
+		KeyFile:        writeFile(t, "client.key", keyPEM),
+		ServerName:     "localhost",
+		CleanSession:   true,
+		KeepAlive:      30 * time.Second,
+		ConnectTimeout: 2 * time.Second,
+	}
+}
+
+func connect(t *testing.T, options ClientOptions, onConnect ...func(MQTT.Client)) MQTT.Client {
+	t.Helper()
+	client, err := ConfigureClient(options, onConnect...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(100) })
+	return client
+}
+
+func TestConnectsWithClientCertificate(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	broker := newFakeBroker(t, ca)
+	client := connect(t, clientOptions(t, broker, ca, ca))
+
+	eventually(t, connectWait, client.IsConnectionOpen, "il client non si e' connesso al broker TLS")
+	connects, _ := broker.stats()
+	if len(connects) != 1 || connects[0] != t.Name() {
+		t.Fatalf("CONNECT ricevuti: %v", connects)
+	}
+	eventually(t, connectWait, func() bool {
+		payload, ok := broker.payload(StatusTopic)
+		return ok && string(payload) == StatusOnline
+	}, "stato online non pubblicato")
+}
+
+func TestRejectsBrokerSignedByUnknownCA(t *testing.T) {
+	brokerCA, otherCA := newTestCA(t, "broker-ca"), newTestCA(t, "other-ca")
+	broker := newFakeBroker(t, brokerCA)
+	// il client ha un certificato valido per il broker ma non si fida della sua CA
+	client := connect(t, clientOptions(t, broker, otherCA, brokerCA))
+
+	eventually(t, connectWait, func() bool {
+		_, fails := broker.stats()
+		return fails > 0
+	}, "nessun tentativo di handshake")
+	time.Sleep(500 * time.Millisecond)
+	if client.IsConnectionOpen() {
+		t.Fatal("connesso a un broker con certificato non fidato")
+	}
+	if connects, _ := broker.stats(); len(connects) != 0 {
+		t.Fatalf("CONNECT ricevuti nonostante il certificato non fidato: %v", connects)
+	}
+}
+
+func TestBrokerRejectsUnknownClientCertificate(t *testing.T) {
+	brokerCA, otherCA := newTestCA(t, "broker-ca"), newTestCA(t, "other-ca")
+	broker := newFakeBroker(t, brokerCA)
+	// il client si fida del broker ma presenta un certificato di un'altra CA
+	client := connect(t, clientOptions(t, broker, brokerCA, otherCA))
+
+	eventually(t, connectWait, func() bool {
+		_, fails := broker.stats()
+		return fails > 0
+	}, "il broker non ha rifiutato il certificato client")
+	time.Sleep(500 * time.Millisecond)
+	if client.IsConnectionOpen() {
+		t.Fatal("connesso con un certificato client non valido")
+	}
+	if connects, _ := broker.stats(); len(connects) != 0 {
+		t.Fatalf("CONNECT ricevuti nonostante il certificato client non valido: %v", connects)
+	}
+}
+
+func TestReconnectsAfterConnectionLoss(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	broker := newFakeBroker(t, ca)
+	var onConnect atomic.Int32
+	client := connect(t, clientOptions(t, broker, ca, ca), func(MQTT.Client) { onConnect.Add(1) })
+
+	eventually(t, connectWait, func() bool { return onConnect.Load() == 1 }, "prima connessione non avvenuta")
+	broker.dropConnections()
+
+	// le sottoscrizioni vengono rifatte nei callback di connessione
+	eventually(t, connectWait, func() bool { return onConnect.Load() == 2 }, "il client non si e' riconnesso")
+	eventually(t, connectWait, client.IsConnectionOpen, "connessione non ripristinata")
+	if connects, _ := broker.stats(); len(connects) != 2 {
+		t.Fatalf("CONNECT ricevuti: %v", connects)
+	}
+}
+
+func TestTLSConfigRequiresCertificateAndKey(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	certPEM, _ := ca.issue(t, "control-unit", x509.ExtKeyUsageClientAuth)
+	options := ClientOptions{CertFile: writeFile(t, "client.crt", certPEM)}
+	if _, err := options.TLSConfig(); err == nil {
+		t.Fatal("certificato senza chiave accettato")
+	}
+	options = ClientOptions{CAFile: writeFile(t, "ca.crt", []byte("non e' un certificato"))}
+	if _, err := options.TLSConfig(); err == nil {
+		t.Fatal("CA bundle non valido accettato")
+	}
+}
@@ -0,0 +1,38 @@
+package mqtt
+
+// Topic usati da una zona. La zona di default mantiene i topic storici,
+// le altre zone usano gli stessi topic con il prefisso zones/<id>/.
+type ZoneTopics struct {
+	Zone            string
+	Name            string
+	IsDefault       bool
+	Sensors         []string
+	Interval        string
+	State           string
+	DevicesPrefix   string
+	DefaultSensorID string
+}
+
+func TopicsForZone(zoneID, name string, isDefault bool) ZoneTopics {
+	topics := ZoneTopics{
+		Zone:            zoneID,
+		Name:            name,
+		IsDefault:       isDefault,
+		Sensors:         []string{"esp32/data/temperature", SensorTopicPattern},
+		Interval:        "esp32/config/interval",
+		State:           StateTopic,
+		DevicesPrefix:   DevicesTopicPrefix,
+		DefaultSensorID: "esp32",
+	}
+	if isDefault {
+		return topics
+	}
+	prefix := "zones/" + zoneID + "/"
+	for i, topic := range topics.Sensors {
+		topics.Sensors[i] = prefix + topic
+	}
+	topics.Interval = prefix + topics.Interval
+	topics.State = "control-unit/zones/" + zoneID + "/state"
+	topics.DevicesPrefix = "control-unit/zones/" + zoneID + "/devices/"
+	return topics
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"server/alarm"
+	"server/config"
+	"server/notify"
+)
+
+// newDispatcher costruisce i canali di notifica degli allarmi a partire dalla configurazione.
+func newDispatcher(cfg config.Notifications) (*notify.Dispatcher, error) {
+	var channels []notify.Channel
+	for _, webhook := range cfg.Webhooks {
+		severity, err := alarm.ParseSeverity(webhook.MinSeverity)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: %w", webhook.URL, err)
+		}
+		channels = append(channels, &notify.Webhook{URL: webhook.URL, Secret: webhook.Secret, Severity: severity})
+	}
+	for _, push := range cfg.Push {
+		severity, err := alarm.ParseSeverity(push.MinSeverity)
+		if err != nil {
+			return nil, fmt.Errorf("push %s: %w", push.URL, err)
+		}
+		channels = append(channels, &notify.Push{URL: push.URL, Token: push.Token, Severity: severity})
+	}
+	if smtp := cfg.SMTP; smtp.Host != "" {
+		severity, err := alarm.ParseSeverity(smtp.MinSeverity)
+		if err != nil {
+			return nil, fmt.Errorf("smtp: %w", err)
+		}
+		channels = append(channels, &notify.Email{
+			Host:     smtp.Host,
+			Port:     smtp.Port,
+			Username: smtp.Username,
+			Password: smtp.Password,
+			From:     smtp.From,
+			To:       smtp.To,
+			Severity: severity,
+		})
+	}
+
+	quietSeverity, err := alarm.ParseSeverity(cfg.QuietHours.MinSeverity)
+	if err != nil {
+		return nil, fmt.Errorf("orario di silenzio: %w", err)
+	}
+	quietHours := notify.QuietHours{
+		Start:       cfg.QuietHours.Start,
+		End:         cfg.QuietHours.End,
+		MinSeverity: quietSeverity,
+	}
+	if err := quietHours.Validate(); err != nil {
+		return nil, err
+	}
+	return notify.NewDispatcher(notify.Config{
+		Retries:    cfg.Retries,
+		RetryDelay: cfg.RetryDelay.Std(),
+		RateLimit:  cfg.RateLimit.Std(),
+		MaxPerHour: cfg.MaxPerHour,
+		QuietHours: quietHours,
+	}, channels...), nil
+}
@@ -0,0 +1,226 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"server/alarm"
+	"server/logging"
+	"server/system"
+	"sync"
+	"time"
+)
+
+var logger = logging.For("notify")
+
+const sendTimeout = 10 * time.Second
+
+// Notifica di un allarme sollevato, aggravato o rientrato.
+type Notification struct {
+	Kind  alarm.ChangeKind
+	Alarm alarm.Alarm
+	Time  time.Time
+}
+
+func (n Notification) Title() string {
+	switch n.Kind {
+	case alarm.ClearedKind:
+		return fmt.Sprintf("[%s] Allarme rientrato: %s", n.Alarm.Zone, n.Alarm.Type)
+	case alarm.Escalated:
+		return fmt.Sprintf("[%s] Allarme %s aggravato: %s", n.Alarm.Zone, n.Alarm.Severity, n.Alarm.Type)
+	default:
+		return fmt.Sprintf("[%s] Allarme %s: %s", n.Alarm.Zone, n.Alarm.Severity, n.Alarm.Type)
+	}
+}
+
+func (n Notification) Text() string {
+	return fmt.Sprintf("%s\nZona: %s\nSeverità: %s\nSollevato: %s\nID: %s",
+		n.Alarm.Message, n.Alarm.Zone, n.Alarm.Severity, n.Alarm.RaisedAt.Format(time.RFC3339), n.Alarm.ID)
+}
+
+// Canale di consegna delle notifiche (webhook, email, push).
+type Channel interface {
+	Name() string
+	MinSeverity() alarm.Severity
+	Send(ctx context.Context, n Notification) error
+}
+
+type Config struct {
+	Retries    int           // tentativi aggiuntivi per ogni canale
+	RetryDelay time.Duration // attesa iniziale tra i tentativi, raddoppiata ad ogni errore
+	RateLimit  time.Duration // intervallo minimo tra due notifiche uguali (zona, tipo, evento)
+	MaxPerHour int           // limite complessivo di notifiche, 0 = nessun limite
+	QuietHours QuietHours
+	QueueSize  int
+}
+
+// Fascia oraria in cui vengono inviate solo le notifiche con severita' almeno MinSeverity.
+// Start ed End nel formato "HH:MM"; se vuoti la fascia e' disabilitata.
+type QuietHours struct {
+	Start       string
+	End         string
+	MinSeverity alarm.Severity
+}
+
+// Validate verifica il formato della fascia; va chiamata prima di creare il Dispatcher.
+func (q QuietHours) Validate() error {
+	if q.Start == "" && q.End == "" {
+		return nil
+	}
+	if q.Start == "" || q.End == "" {
+		return fmt.Errorf("orario di silenzio incompleto: inizio %q, fine %q", q.Start, q.End)
+	}
+	for _, s := range []string{q.Start, q.End} {
+		if _, err := time.Parse("15:04", s); err != nil {
+			return fmt.Errorf("orario di silenzio non valido %q, atteso HH:MM", s)
+		}
+	}
+	return nil
+}
+
+func (q QuietHours) contains(now time.Time) bool {
+	if q.Start == "" || q.End == "" {
+		return false
+	}
+	// il formato e' verificato da Validate
+	start, _ := time.Parse("15:04", q.Start)
+	end, _ := time.Parse("15:04", q.End)
+	minutes := now.Hour()*60 + now.Minute()
+	from, to := start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+	if from <= to {
+		return minutes >= from && minutes < to
+	}
+	return minutes >= from || minutes < to
+}
+
+type dedupKey struct {
+	zone string
+	kind alarm.Type
+	what alarm.ChangeKind
+}
+
+// Dispatcher riceve le variazioni degli allarmi e le consegna ai canali configurati
+// in background, senza mai bloccare il manager degli allarmi.
+type Dispatcher struct {
+	config   Config
+	channels []Channel
+	queue    chan Notification
+
+	mu       sync.Mutex
+	lastSent map[dedupKey]time.Time
+	sentHour []time.Time
+}
+
+func NewDispatcher(config Config, channels ...Channel) *Dispatcher {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 100
+	}
+	return &Dispatcher{
+		config:   config,
+		channels: channels,
+		queue:    make(chan Notification, config.QueueSize),
+		lastSent: make(map[dedupKey]time.Time),
+	}
+}
+
+// HandleChange va registrato con alarm.Manager.OnChange. Vengono notificati solo gli
+// allarmi sollevati, aggravati o rientrati e non accantonati.
+func (d *Dispatcher) HandleChange(change alarm.Change) {
+	switch change.Kind {
+	case alarm.Raised, alarm.Escalated, alarm.ClearedKind:
+	default:
+		return
+	}
+	now := time.Now()
+	if change.Alarm.Shelved(now) {
+		return
+	}
+	select {
+	case d.queue <- Notification{Kind: change.Kind, Alarm: change.Alarm, Time: now}:
+	default:
+		system.DroppedEntries.With(change.Alarm.Zone, "notify").Inc()
+		logger.Warn("buffer full, notification dropped", "buffer", "notify", "alarm", change.Alarm.Type, "kind", change.Kind)
+	}
+}
+
+// allow applica orari di silenzio, deduplicazione e limite orario.
+func (d *Dispatcher) allow(n Notification) bool {
+	if d.config.QuietHours.contains(n.Time) && n.Alarm.Severity < d.config.QuietHours.MinSeverity {
+		logger.Info("notification suppressed by quiet hours", "alarm", n.Alarm.Type, "kind", n.Kind, "zone", n.Alarm.Zone)
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	k := dedupKey{n.Alarm.Zone, n.Alarm.Type, n.Kind}
+	if last, ok := d.lastSent[k]; ok && n.Time.Sub(last) < d.config.RateLimit {
+		logger.Info("duplicate notification suppressed", "alarm", n.Alarm.Type, "kind", n.Kind, "zone", n.Alarm.Zone)
+		return false
+	}
+	if d.config.MaxPerHour > 0 {
+		recent := d.sentHour[:0]
+		for _, t := range d.sentHour {
+			if n.Time.Sub(t) < time.Hour {
+				recent = append(recent, t)
+			}
+		}
+		d.sentHour = recent
+		if len(d.sentHour) >= d.config.MaxPerHour {
+			logger.Warn("hourly notification limit reached", "limit", d.config.MaxPerHour, "alarm", n.Alarm.Type, "zone", n.Alarm.Zone)
+			return false
+		}
+		d.sentHour = append(d.sentHour, n.Time)
+	}
+	d.lastSent[k] = n.Time
+	return true
+}
+
+// Run consegna le notifiche finche' ctx non viene cancellato; ogni canale e' servito
+// in una goroutine separata cosi' che un canale lento non ritardi gli altri.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case n := <-d.queue:
+			if !d.allow(n) {
+				continue
+			}
+			for _, channel := range d.channels {
+				if n.Alarm.Severity < channel.MinSeverity() {
+					continue
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					d.deliver(ctx, channel, n)
+				}()
+			}
+		case <-ctx.Done():
+			logger.Info("dispatcher stopped")
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, channel Channel, n Notification) {
+	delay := d.config.RetryDelay
+	for attempt := 0; ; attempt++ {
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err := channel.Send(sendCtx, n)
+		cancel()
+		if err == nil {
+			return
+		}
+		if attempt >= d.config.Retries {
+			logger.Error("notification delivery failed", "channel", channel.Name(), "attempts", attempt+1, "error", err)
+			return
+		}
+		logger.Warn("notification delivery failed, retrying", "channel", channel.Name(), "retry_in", delay, "error", err)
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return
+		}
+	}
+}
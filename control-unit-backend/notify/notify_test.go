@@ -0,0 +1,213 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"server/alarm"
+	"sync"
+	"testing"
+	"time"
+)
+
+// canale di prova che fallisce i primi failures invii
+type fakeChannel struct {
+	severity alarm.Severity
+	failures int
+
+	mu       sync.Mutex
+	attempts int
+	sent     chan Notification
+}
+
+func newFakeChannel(severity alarm.Severity, failures int) *fakeChannel {
+	return &fakeChannel{severity: severity, failures: failures, sent: make(chan Notification, 10)}
+}
+
+func (c *fakeChannel) Name() string                { return "fake" }
+func (c *fakeChannel) MinSeverity() alarm.Severity { return c.severity }
+
+func (c *fakeChannel) Send(ctx context.Context, n Notification) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts++
+	if c.attempts <= c.failures {
+		return errors.New("canale non raggiungibile")
+	}
+	c.sent <- n
+	return nil
+}
+
+func (c *fakeChannel) attemptCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.attempts
+}
+
+func notification(zone string, kind alarm.ChangeKind, severity alarm.Severity, at time.Time) Notification {
+	return Notification{
+		Kind:  kind,
+		Alarm: alarm.Alarm{Zone: zone, Type: alarm.OverTemperature, Severity: severity},
+		Time:  at,
+	}
+}
+
+var noon = time.Date(2025, 7, 1, 12, 0, 0, 0, time.Local)
+
+// runDispatcher avvia il dispatcher fino alla fine del test.
+func runDispatcher(t *testing.T, d *Dispatcher) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestDispatcherRetriesFailedDelivery(t *testing.T) {
+	channel := newFakeChannel(alarm.Warning, 2)
+	d := NewDispatcher(Config{Retries: 2, RetryDelay: time.Millisecond}, channel)
+	runDispatcher(t, d)
+
+	d.HandleChange(alarm.Change{Kind: alarm.Raised, Alarm: alarm.Alarm{Zone: "serra", Type: alarm.OverTemperature}})
+	select {
+	case n := <-channel.sent:
+		if n.Alarm.Zone != "serra" {
+			t.Fatalf("notifica consegnata per la zona %q", n.Alarm.Zone)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("notifica non consegnata dopo i tentativi")
+	}
+	if attempts := channel.attemptCount(); attempts != 3 {
+		t.Fatalf("%d tentativi invece di 3", attempts)
+	}
+}
+
+func TestDispatcherGivesUpAfterRetries(t *testing.T) {
+	channel := newFakeChannel(alarm.Warning, 10)
+	d := NewDispatcher(Config{Retries: 1, RetryDelay: time.Millisecond}, channel)
+	runDispatcher(t, d)
+
+	d.HandleChange(alarm.Change{Kind: alarm.Raised, Alarm: alarm.Alarm{Zone: "serra", Type: alarm.OverTemperature}})
+	time.Sleep(200 * time.Millisecond)
+	if attempts := channel.attemptCount(); attempts != 2 {
+		t.Fatalf("%d tentativi invece di 2", attempts)
+	}
+}
+
+func TestDispatcherSkipsIgnoredChanges(t *testing.T) {
+	warnings := newFakeChannel(alarm.Warning, 0)
+	critical := newFakeChannel(alarm.Critical, 0)
+	d := NewDispatcher(Config{}, warnings, critical)
+	runDispatcher(t, d)
+
+	// il riconoscimento e gli allarmi accantonati non vengono notificati
+	d.HandleChange(alarm.Change{Kind: alarm.AcknowledgedKind, Alarm: alarm.Alarm{Zone: "a"}})
+	d.HandleChange(alarm.Change{Kind: alarm.Raised, Alarm: alarm.Alarm{Zone: "b", ShelvedUntil: time.Now().Add(time.Hour)}})
+	d.HandleChange(alarm.Change{Kind: alarm.Raised, Alarm: alarm.Alarm{Zone: "c", Severity: alarm.Major}})
+
+	select {
+	case n := <-warnings.sent:
+		if n.Alarm.Zone != "c" {
+			t.Fatalf("notificata la zona %q", n.Alarm.Zone)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("notifica non consegnata")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(warnings.sent) != 0 {
+		t.Fatalf("%d notifiche in piu' consegnate", len(warnings.sent))
+	}
+	// la severita' e' sotto la soglia del canale
+	if attempts := critical.attemptCount(); attempts != 0 {
+		t.Fatalf("canale critico usato %d volte per un allarme MAJOR", attempts)
+	}
+}
+
+func TestDispatcherSuppressesDuplicates(t *testing.T) {
+	d := NewDispatcher(Config{RateLimit: 10 * time.Minute})
+
+	if !d.allow(notification("serra", alarm.Raised, alarm.Major, noon)) {
+		t.Fatal("prima notifica soppressa")
+	}
+	if d.allow(notification("serra", alarm.Raised, alarm.Major, noon.Add(5*time.Minute))) {
+		t.Fatal("notifica duplicata non soppressa")
+	}
+	// evento o zona diversi non sono duplicati
+	if !d.allow(notification("serra", alarm.ClearedKind, alarm.Major, noon.Add(5*time.Minute))) {
+		t.Fatal("rientro soppresso come duplicato del sollevamento")
+	}
+	if !d.allow(notification("cantina", alarm.Raised, alarm.Major, noon.Add(5*time.Minute))) {
+		t.Fatal("notifica di un'altra zona soppressa")
+	}
+	// la finestra parte dall'ultima notifica inviata
+	if !d.allow(notification("serra", alarm.Raised, alarm.Major, noon.Add(10*time.Minute))) {
+		t.Fatal("notifica soppressa oltre l'intervallo minimo")
+	}
+}
+
+func TestDispatcherQuietHours(t *testing.T) {
+	d := NewDispatcher(Config{QuietHours: QuietHours{Start: "22:00", End: "06:00", MinSeverity: alarm.Critical}})
+	night := time.Date(2025, 7, 1, 23, 30, 0, 0, time.Local)
+	morning := time.Date(2025, 7, 2, 5, 59, 0, 0, time.Local)
+
+	tests := []struct {
+		name     string
+		zone     string
+		severity alarm.Severity
+		at       time.Time
+		allowed  bool
+	}{
+		{"di giorno", "a", alarm.Warning, noon, true},
+		{"di notte", "b", alarm.Major, night, false},
+		{"dopo mezzanotte", "c", alarm.Warning, morning, false},
+		{"critico di notte", "d", alarm.Critical, night, true},
+		{"alla fine della fascia", "e", alarm.Warning, time.Date(2025, 7, 2, 6, 0, 0, 0, time.Local), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allowed := d.allow(notification(tt.zone, alarm.Raised, tt.severity, tt.at)); allowed != tt.allowed {
+				t.Fatalf("allow = %v, atteso %v", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestDispatcherHourlyCap(t *testing.T) {
+	d := NewDispatcher(Config{MaxPerHour: 2})
+
+	for i, zone := range []string{"a", "b"} {
+		if !d.allow(notification(zone, alarm.Raised, alarm.Major, noon.Add(time.Duration(i)*time.Minute))) {
+			t.Fatalf("notifica %d soppressa entro il limite", i+1)
+		}
+	}
+	if d.allow(notification("c", alarm.Raised, alarm.Critical, noon.Add(30*time.Minute))) {
+		t.Fatal("limite orario superato")
+	}
+	// un'ora dopo la prima notifica si libera un posto
+	if !d.allow(notification("c", alarm.Raised, alarm.Critical, noon.Add(time.Hour))) {
+		t.Fatal("notifica soppressa dopo la scadenza della prima")
+	}
+	if d.allow(notification("d", alarm.Raised, alarm.Critical, noon.Add(time.Hour))) {
+		t.Fatal("limite orario superato dopo la scadenza della prima notifica")
+	}
+}
+
+func TestQuietHoursValidate(t *testing.T) {
+	valid := []QuietHours{{}, {Start: "22:00", End: "06:30"}, {Start: "00:00", End: "23:59"}}
+	for _, q := range valid {
+		if err := q.Validate(); err != nil {
+			t.Errorf("%+v: %v", q, err)
+		}
+	}
+	invalid := []QuietHours{{Start: "22:00"}, {End: "06:00"}, {Start: "22", End: "06:00"}, {Start: "22:00", End: "25:00"}, {Start: "ore 22", End: "06:00"}}
+	for _, q := range invalid {
+		if err := q.Validate(); err == nil {
+			t.Errorf("%+v accettato", q)
+		}
+	}
+}
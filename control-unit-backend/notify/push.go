@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"server/alarm"
+	"strings"
+)
+
+// Push invia la notifica a un server compatibile con ntfy: il corpo e' il testo del messaggio
+// e titolo, priorita' e tag sono passati negli header.
+type Push struct {
+	URL      string // es. https://ntfy.sh/mio-topic
+	Token    string
+	Severity alarm.Severity
+	Client   *http.Client
+}
+
+func (p *Push) Name() string                { return "push " + p.URL }
+func (p *Push) MinSeverity() alarm.Severity { return p.Severity }
+
+func pushPriority(n Notification) string {
+	if n.Kind == alarm.ClearedKind {
+		return "default"
+	}
+	switch n.Alarm.Severity {
+	case alarm.Critical:
+		return "urgent"
+	case alarm.Major:
+		return "high"
+	default:
+		return "default"
+	}
+}
+
+func (p *Push) Send(ctx context.Context, n Notification) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, strings.NewReader(n.Text()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", n.Title())
+	req.Header.Set("Priority", pushPriority(n))
+	tag := "warning"
+	if n.Kind == alarm.ClearedKind {
+		tag = "white_check_mark"
+	}
+	req.Header.Set("Tags", tag)
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+	return doRequest(p.Client, req)
+}
@@ -0,0 +1,169 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"server/alarm"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Server SMTP minimo senza STARTTLS ne' autenticazione: registra mittente, destinatari
+// e messaggio della prima sessione.
+type fakeSMTP struct {
+	listener net.Listener
+	mail     chan smtpMail
+}
+
+type smtpMail struct {
+	from string
+	to   []string
+	data string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{listener: listener, mail: make(chan smtpMail, 1)}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTP) port(t *testing.T) int {
+	t.Helper()
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func (s *fakeSMTP) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	var mail smtpMail
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case command == "EHLO":
+			reply("250-localhost")
+			reply("250 8BITMIME")
+		case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+			mail.from = address(line[len("MAIL FROM:"):])
+			reply("250 OK")
+		case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+			mail.to = append(mail.to, address(line[len("RCPT TO:"):]))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 fine con <CRLF>.<CRLF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			mail.data = data.String()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 arrivederci")
+			s.mail <- mail
+			return
+		default:
+			reply("502 comando non implementato")
+		}
+	}
+}
+
+// address estrae l'indirizzo da "<indirizzo> PARAMETRI".
+func address(arg string) string {
+	arg, _, _ = strings.Cut(strings.TrimPrefix(strings.TrimSpace(arg), "<"), ">")
+	return arg
+}
+
+func TestEmailSend(t *testing.T) {
+	server := newFakeSMTP(t)
+	email := &Email{Host: "127.0.0.1", Port: server.port(t), From: "centralina@example.com", To: []string{"a@example.com", "b@example.com"}}
+	n := notification("serra", alarm.Raised, alarm.Critical, noon)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := email.Send(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+	mail := <-server.mail
+	if mail.from != email.From {
+		t.Errorf("mittente %q", mail.from)
+	}
+	if strings.Join(mail.to, ",") != "a@example.com,b@example.com" {
+		t.Errorf("destinatari %v", mail.to)
+	}
+	if !strings.Contains(mail.data, "Subject: ") || !strings.Contains(mail.data, "Zona: serra\r\n") {
+		t.Errorf("messaggio %q", mail.data)
+	}
+}
+
+func TestEmailSendStopsAtContextDeadline(t *testing.T) {
+	// il server accetta la connessione ma non risponde mai
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	closed := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// la lettura termina quando il client chiude la connessione
+		conn.Read(make([]byte, 1))
+		close(closed)
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	email := &Email{Host: "127.0.0.1", Port: p, From: "centralina@example.com", To: []string{"a@example.com"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = email.Send(ctx, notification("serra", alarm.Raised, alarm.Critical, noon))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("errore %v, atteso il superamento del deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Send e' tornato dopo %v", elapsed)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("la connessione SMTP e' rimasta aperta dopo il deadline")
+	}
+}
@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"server/alarm"
+	"strconv"
+)
+
+// SignatureHeader contiene l'HMAC-SHA256 del corpo della richiesta, nel formato "sha256=<hex>".
+const SignatureHeader = "X-Signature-256"
+
+// Webhook invia la notifica in JSON con una POST; se Secret e' impostato il corpo viene firmato.
+type Webhook struct {
+	URL      string
+	Secret   string
+	Severity alarm.Severity
+	Client   *http.Client
+}
+
+type webhookPayload struct {
+	Kind  alarm.ChangeKind
+	Title string
+	Alarm alarm.Alarm
+	Time  int64 // unix, secondi
+}
+
+func (w *Webhook) Name() string                { return "webhook " + w.URL }
+func (w *Webhook) MinSeverity() alarm.Severity { return w.Severity }
+
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *Webhook) Send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(webhookPayload{Kind: n.Kind, Title: n.Title(), Alarm: n.Alarm, Time: n.Time.Unix()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Timestamp", strconv.FormatInt(n.Time.Unix(), 10))
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.Secret, body))
+	}
+	return doRequest(w.Client, req)
+}
+
+func doRequest(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("risposta %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"server/alarm"
+	"strconv"
+	"testing"
+)
+
+// request raccoglie l'ultima richiesta ricevuta dal server di prova.
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+func newServer(t *testing.T, status int) (*httptest.Server, <-chan request) {
+	t.Helper()
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{header: r.Header.Clone(), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestWebhookSignsBody(t *testing.T) {
+	server, requests := newServer(t, http.StatusNoContent)
+	webhook := &Webhook{URL: server.URL, Secret: "segreto", Client: server.Client()}
+	n := notification("serra", alarm.Raised, alarm.Major, noon)
+
+	if err := webhook.Send(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+	if got, want := req.header.Get(SignatureHeader), Sign("segreto", req.body); got != want {
+		t.Fatalf("firma %q, attesa %q", got, want)
+	}
+	if Sign("altro", req.body) == req.header.Get(SignatureHeader) {
+		t.Fatal("la firma non dipende dal segreto")
+	}
+	if got := req.header.Get("X-Timestamp"); got != strconv.FormatInt(noon.Unix(), 10) {
+		t.Fatalf("X-Timestamp = %q", got)
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Kind != alarm.Raised || payload.Alarm.Zone != "serra" || payload.Title != n.Title() || payload.Time != noon.Unix() {
+		t.Fatalf("payload %+v", payload)
+	}
+}
+
+func TestWebhookWithoutSecretIsUnsigned(t *testing.T) {
+	server, requests := newServer(t, http.StatusOK)
+	webhook := &Webhook{URL: server.URL, Client: server.Client()}
+	if err := webhook.Send(context.Background(), notification("serra", alarm.Raised, alarm.Major, noon)); err != nil {
+		t.Fatal(err)
+	}
+	if sig := (<-requests).header.Get(SignatureHeader); sig != "" {
+		t.Fatalf("richiesta firmata senza segreto: %q", sig)
+	}
+}
+
+func TestWebhookReportsErrorStatus(t *testing.T) {
+	server, _ := newServer(t, http.StatusInternalServerError)
+	webhook := &Webhook{URL: server.URL, Client: server.Client()}
+	if err := webhook.Send(context.Background(), notification("serra", alarm.Raised, alarm.Major, noon)); err == nil {
+		t.Fatal("risposta 500 considerata un successo")
+	}
+}
+
+func TestPushHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     alarm.ChangeKind
+		severity alarm.Severity
+		priority string
+		tag      string
+	}{
+		{"critico", alarm.Raised, alarm.Critical, "urgent", "warning"},
+		{"maggiore", alarm.Escalated, alarm.Major, "high", "warning"},
+		{"avviso", alarm.Raised, alarm.Warning, "default", "warning"},
+		{"rientrato", alarm.ClearedKind, alarm.Critical, "default", "white_check_mark"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newServer(t, http.StatusOK)
+			push := &Push{URL: server.URL, Token: "tk", Client: server.Client()}
+			n := notification("serra", tt.kind, tt.severity, noon)
+			if err := push.Send(context.Background(), n); err != nil {
+				t.Fatal(err)
+			}
+			req := <-requests
+			if got := req.header.Get("Title"); got != n.Title() {
+				t.Errorf("Title = %q", got)
+			}
+			if got := req.header.Get("Priority"); got != tt.priority {
+				t.Errorf("Priority = %q, attesa %q", got, tt.priority)
+			}
+			if got := req.header.Get("Tags"); got != tt.tag {
+				t.Errorf("Tags = %q, atteso %q", got, tt.tag)
+			}
+			if got := req.header.Get("Authorization"); got != "Bearer tk" {
+				t.Errorf("Authorization = %q", got)
+			}
+			if string(req.body) != n.Text() {
+				t.Errorf("corpo %q", req.body)
+			}
+		})
+	}
+}
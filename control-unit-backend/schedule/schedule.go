@@ -0,0 +1,255 @@
+package schedule
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"server/system"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Profilo di soglie applicato mentre una voce del calendario e' attiva.
+type Profile struct {
+	Threshold1        float64
+	Threshold2        float64
+	ForceWindowClosed bool // in Normal e Hot la finestra resta chiusa
+}
+
+// Voce del calendario settimanale. Start ed End sono nel formato "HH:MM" e l'intervallo
+// puo' attraversare la mezzanotte (Start uguale a End indica l'intera giornata); Days vuoto
+// significa tutti i giorni. Una voce e' attiva finche' non viene disabilitata esplicitamente.
+type Entry struct {
+	ID       string
+	Zone     string   // vuoto: tutte le zone
+	Days     []string // "mon", "tue", ... "sun"
+	Start    string
+	End      string
+	Mode     string // "", "manual" o "automatic": modalita' impostata all'inizio dell'intervallo
+	Profile  *Profile
+	Disabled bool `json:",omitempty"`
+}
+
+// Effetto complessivo delle voci attive in un certo istante; a parita' di campo vale l'ultima voce.
+type Active struct {
+	EntryIDs []string
+	Mode     *system.OperativeMode
+	Profile  *Profile
+}
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("orario non valido %q, atteso HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func parseMode(s string) (*system.OperativeMode, error) {
+	var mode system.OperativeMode
+	switch strings.ToLower(s) {
+	case "":
+		return nil, nil
+	case "manual":
+		mode = system.Manual
+	case "automatic":
+		mode = system.Automatic
+	default:
+		return nil, fmt.Errorf("%w: %q", system.ErrInvalidMode, s)
+	}
+	return &mode, nil
+}
+
+func (e Entry) Validate() error {
+	if _, err := parseClock(e.Start); err != nil {
+		return err
+	}
+	if _, err := parseClock(e.End); err != nil {
+		return err
+	}
+	for _, day := range e.Days {
+		if _, ok := dayNames[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("giorno non valido %q", day)
+		}
+	}
+	if _, err := parseMode(e.Mode); err != nil {
+		return err
+	}
+	if e.Profile != nil && e.Profile.Threshold1 >= e.Profile.Threshold2 {
+		return fmt.Errorf("Threshold1 deve essere minore di Threshold2")
+	}
+	if e.Mode == "" && e.Profile == nil {
+		return fmt.Errorf("la voce deve impostare una modalità o un profilo")
+	}
+	return nil
+}
+
+// activeAt indica se la voce e' attiva nell'istante dato. Per gli intervalli che attraversano
+// la mezzanotte il giorno considerato e' quello in cui l'intervallo inizia.
+func (e Entry) activeAt(now time.Time) bool {
+	if e.Disabled {
+		return false
+	}
+	start, _ := parseClock(e.Start)
+	end, _ := parseClock(e.End)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	sinceMidnight := now.Sub(midnight)
+
+	day := now.Weekday()
+	var inside bool
+	switch {
+	case start < end:
+		inside = sinceMidnight >= start && sinceMidnight < end
+	case sinceMidnight >= start:
+		inside = true
+	case sinceMidnight < end:
+		inside = true
+		day = (day + 6) % 7 // l'intervallo e' iniziato il giorno prima
+	}
+	if !inside {
+		return false
+	}
+	if len(e.Days) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(e.Days, func(d string) bool { return dayNames[strings.ToLower(d)] == day })
+}
+
+// Store mantiene il calendario e lo salva su disco ad ogni modifica; e' usato
+// in concorrenza dalle API e dai system manager delle zone.
+type Store struct {
+	mu      sync.RWMutex
+	path    string
+	entries []Entry
+}
+
+// Open carica il calendario da path; se il file non esiste il calendario e' vuoto.
+// Con path vuoto il calendario resta solo in memoria.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("errore lettura calendario: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		return nil, fmt.Errorf("calendario non valido %s: %w", path, err)
+	}
+	for _, e := range s.entries {
+		if err := e.Validate(); err != nil {
+			return nil, fmt.Errorf("voce %s del calendario non valida: %w", e.ID, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) List() []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.entries)
+}
+
+// Put aggiunge una voce o sostituisce quella con lo stesso ID.
+func (s *Store) Put(e Entry) (Entry, error) {
+	if err := e.Validate(); err != nil {
+		return e, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	entries := slices.Clone(s.entries)
+	if i := slices.IndexFunc(entries, func(x Entry) bool { return x.ID == e.ID }); i >= 0 {
+		entries[i] = e
+	} else {
+		entries = append(entries, e)
+	}
+	if err := s.save(entries); err != nil {
+		return e, err
+	}
+	s.entries = entries
+	return e, nil
+}
+
+func (s *Store) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.entries, func(x Entry) bool { return x.ID == id })
+	if i < 0 {
+		return false, nil
+	}
+	entries := slices.Delete(slices.Clone(s.entries), i, i+1)
+	if err := s.save(entries); err != nil {
+		return false, err
+	}
+	s.entries = entries
+	return true, nil
+}
+
+// Active restituisce l'effetto delle voci attive per la zona nell'istante dato.
+func (s *Store) Active(zone string, now time.Time) Active {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var active Active
+	for _, e := range s.entries {
+		if (e.Zone != "" && e.Zone != zone) || !e.activeAt(now) {
+			continue
+		}
+		active.EntryIDs = append(active.EntryIDs, e.ID)
+		if mode, _ := parseMode(e.Mode); mode != nil {
+			active.Mode = mode
+		}
+		if e.Profile != nil {
+			active.Profile = e.Profile
+		}
+	}
+	return active
+}
+
+func (s *Store) save(entries []Entry) error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("errore salvataggio calendario: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("errore salvataggio calendario: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("errore salvataggio calendario: %w", err)
+	}
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,142 @@
+package schedule
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"server/system"
+	"slices"
+	"testing"
+	"time"
+)
+
+// at restituisce l'orario clock ("HH:MM") del giorno day di luglio 2025; il primo e' un martedi'.
+func at(day int, clock string) time.Time {
+	d, err := parseClock(clock)
+	if err != nil {
+		panic(err)
+	}
+	return time.Date(2025, time.July, day, 0, 0, 0, 0, time.Local).Add(d)
+}
+
+func TestActiveAtWithinDay(t *testing.T) {
+	e := Entry{Start: "08:00", End: "18:00"}
+	for _, c := range []struct {
+		now  time.Time
+		want bool
+	}{
+		{at(1, "07:59"), false},
+		{at(1, "08:00"), true},
+		{at(1, "17:59"), true},
+		{at(1, "18:00"), false},
+	} {
+		if got := e.activeAt(c.now); got != c.want {
+			t.Errorf("%v: attiva %v, attesa %v", c.now, got, c.want)
+		}
+	}
+}
+
+func TestActiveAtAcrossMidnightUsesStartDay(t *testing.T) {
+	// la notte del venerdi': dalle 22 di venerdi' alle 6 di sabato
+	e := Entry{Days: []string{"fri"}, Start: "22:00", End: "06:00"}
+	for _, c := range []struct {
+		now  time.Time
+		want bool
+	}{
+		{at(4, "23:00"), true},  // venerdi'
+		{at(5, "05:59"), true},  // sabato mattina, intervallo iniziato venerdi'
+		{at(5, "06:00"), false}, // fine dell'intervallo
+		{at(5, "23:00"), false}, // sabato sera non e' in Days
+		{at(4, "05:00"), false}, // venerdi' mattina: l'intervallo sarebbe iniziato giovedi'
+	} {
+		if got := e.activeAt(c.now); got != c.want {
+			t.Errorf("%v (%v): attiva %v, attesa %v", c.now, c.now.Weekday(), got, c.want)
+		}
+	}
+}
+
+func TestActiveAtOnWeekends(t *testing.T) {
+	e := Entry{Days: []string{"Sat", "sun"}, Start: "00:00", End: "00:00"}
+	for day := 1; day <= 7; day++ {
+		now := at(day, "12:00")
+		want := now.Weekday() == time.Saturday || now.Weekday() == time.Sunday
+		if got := e.activeAt(now); got != want {
+			t.Errorf("%v: attiva %v, attesa %v", now.Weekday(), got, want)
+		}
+	}
+	// l'intera giornata copre anche la mezzanotte d'inizio e l'ultimo minuto
+	if !e.activeAt(at(5, "00:00")) || !e.activeAt(at(6, "23:59")) || e.activeAt(at(7, "00:00")) {
+		t.Error("giornata intera del fine settimana non rispettata")
+	}
+}
+
+func TestEntriesAreEnabledByDefault(t *testing.T) {
+	var e Entry
+	if err := json.Unmarshal([]byte(`{"Start":"08:00","End":"09:00","Mode":"manual"}`), &e); err != nil {
+		t.Fatal(err)
+	}
+	if !e.activeAt(at(1, "08:30")) {
+		t.Fatal("voce senza campo di abilitazione ignorata")
+	}
+	e.Disabled = true
+	if e.activeAt(at(1, "08:30")) {
+		t.Fatal("voce disabilitata attiva")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, e := range []Entry{
+		{Start: "08:00", End: "9", Mode: "manual"},
+		{Start: "08:00", End: "24:00", Mode: "manual"},
+		{Start: "08:00", End: "09:00", Days: []string{"lun"}, Mode: "manual"},
+		{Start: "08:00", End: "09:00", Mode: "auto"},
+		{Start: "08:00", End: "09:00", Profile: &Profile{Threshold1: 30, Threshold2: 25}},
+		{Start: "08:00", End: "09:00"},
+	} {
+		if err := e.Validate(); err == nil {
+			t.Errorf("voce %+v accettata", e)
+		}
+	}
+	if err := (Entry{Start: "22:00", End: "06:00", Days: []string{"MON"}, Mode: "Automatic"}).Validate(); err != nil {
+		t.Errorf("voce valida rifiutata: %v", err)
+	}
+}
+
+func TestStoreActiveMergesEntriesAndPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(e Entry) {
+		t.Helper()
+		if _, err := s.Put(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(Entry{ID: "notte", Start: "20:00", End: "07:00", Mode: "manual"})
+	put(Entry{ID: "estate", Zone: "serra", Start: "00:00", End: "00:00", Profile: &Profile{Threshold1: 26, Threshold2: 32}})
+	put(Entry{ID: "ufficio", Zone: "ufficio", Start: "00:00", End: "00:00", Profile: &Profile{Threshold1: 22, Threshold2: 28}})
+	put(Entry{ID: "sera", Start: "19:00", End: "23:00", Mode: "automatic", Disabled: true})
+
+	active := s.Active("serra", at(1, "21:00"))
+	if !slices.Equal(active.EntryIDs, []string{"notte", "estate"}) {
+		t.Fatalf("voci attive %v", active.EntryIDs)
+	}
+	if active.Mode == nil || *active.Mode != system.Manual || active.Profile == nil || active.Profile.Threshold1 != 26 {
+		t.Fatalf("effetto delle voci attive %+v", active)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.List(); len(got) != 4 || !got[3].Disabled {
+		t.Fatalf("calendario riletto %+v", got)
+	}
+	if deleted, err := reopened.Delete("notte"); !deleted || err != nil {
+		t.Fatalf("cancellazione: %v %v", deleted, err)
+	}
+	if active := reopened.Active("serra", at(1, "21:00")); active.Mode != nil {
+		t.Fatalf("modalita' ancora impostata dopo la cancellazione: %v", *active.Mode)
+	}
+}
@@ -2,56 +2,79 @@ package main
 
 import (
 	"context"
-	"log"
-	"math"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"server/alarm"
 	"server/arduinoserial"
+	"server/audit"
+	"server/auth"
+	"server/bus"
+	"server/config"
+	"server/logging"
 	"server/mqtt"
+	"server/schedule"
+	"server/snapshot"
+	"server/supervisor"
 	"server/system"
 	"server/webserver"
-	"strconv"
-	"sync"
+	"slices"
 	"syscall"
 	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 )
 
-type Channels struct {
-	IntervalUpdatesChan chan time.Duration
-	TempUpdatesChan     chan float64
-	CommandRequestChan  chan system.RequestType
-	StateRequestChan    chan chan system.SystemState
-	DataFromArduinoChan chan arduinoserial.DataFromArduino
-	DataToArduinoChan   chan arduinoserial.DataToArduino
-}
+var logger = logging.For("system")
 
 func systemManager(
 	ctx context.Context,
-	ch Channels,
+	zoneConfig config.Zone,
+	b zoneBus,
+	in managerInputs,
+	sensors *system.SensorSet,
+	actuator *system.ActuatorSupervisor,
+	strategy system.WindowStrategy,
+	schedules *schedule.Store,
+	alarms *alarm.Manager,
+	auditLog *audit.Log,
+	snapshots *snapshot.Store,
+	restorePolicy snapshot.Policy,
+	snapshotInterval time.Duration,
 ) {
 	const (
-		normalFreq time.Duration = 500 * time.Millisecond
-		fastFreq   time.Duration = 100 * time.Millisecond
-		threshold1 float64       = 30
-		threshold2 float64       = 70
-
-		esp32TimeoutTime  = 2 * time.Second
-		tooHotMaxDuration = 10 * time.Second
-
+		sensorCheckFreq   = 1 * time.Second
 		arduinoSerialFreq = 250 * time.Millisecond
+		scheduleCheckFreq = 15 * time.Second
+		// Arduino invia la posizione della finestra di continuo: senza dati per questo tempo e' offline
+		arduinoTimeout = 5 * time.Second
+	)
+	var (
+		normalFreq        = zoneConfig.NormalFreq.Std()
+		fastFreq          = zoneConfig.FastFreq.Std()
+		threshold1        = zoneConfig.Threshold1
+		threshold2        = zoneConfig.Threshold2
+		tooHotMaxDuration = zoneConfig.TooHotMaxDuration.Std()
 	)
 
-	esp32TimeoutTimer := time.NewTimer(esp32TimeoutTime)
+	sensorCheckTicker := time.NewTicker(sensorCheckFreq)
+	defer sensorCheckTicker.Stop()
 	arduinoTimer := time.NewTimer(arduinoSerialFreq)
+	scheduleTicker := time.NewTicker(scheduleCheckFreq)
+	defer scheduleTicker.Stop()
 	var tooHotEnteredAt time.Time
+	var arduinoLastSeen time.Time
 
 	var tempHistory = make([]float64, 0, system.MaxTemperatureBuffer)
+	logger := logger.With("zone", zoneConfig.ID)
 
-	windowManualCommand := 0
+	var manualControl system.ManualControl
+	manualLease := system.ManualLease{Duration: zoneConfig.ManualLease.Std()}
+	executor := commandExecutor{manualControl: &manualControl, manualLease: &manualLease, auditLog: auditLog}
 
 	actualSystemState := system.SystemState{
+		Zone:                zoneConfig.ID,
 		Status:              system.Normal,
 		StatusString:        system.Normal.String(),
 		SamplingInterval:    normalFreq,
@@ -59,182 +82,588 @@ func systemManager(
 		OperativeModeString: system.Automatic.String(),
 		CurrentTemp:         0,
 		AverageTemp:         0,
-		MinTemp:             math.Inf(1),
-		MaxTemp:             math.Inf(-1),
 		WindowPosition:      0,
+		Threshold1:          threshold1,
+		Threshold2:          threshold2,
 		DevicesOnline: map[system.DeviceName]bool{
 			"server":  true,
 			"esp32":   false,
 			"arduino": false,
 		},
 	}
+	if saved, ok, err := snapshots.Load(zoneConfig.ID); err != nil {
+		logger.Warn("saved state not restored", "error", err)
+	} else if ok {
+		tempHistory = restoreSnapshot(saved, restorePolicy, &actualSystemState, tempHistory, &manualLease, fastFreq, time.Now())
+	}
+	threshold1, threshold2 = applySchedule(&actualSystemState, zoneConfig, schedules, executor, time.Now())
+
+	publishedState := actualSystemState.Clone()
+	b.state.Publish(publishedState)
+
+	// lo stato viene salvato periodicamente e subito quando cambiano modalita', stato o allarmi
+	var snapshotTick <-chan time.Time
+	if snapshots.Enabled() && snapshotInterval > 0 {
+		snapshotTicker := time.NewTicker(snapshotInterval)
+		defer snapshotTicker.Stop()
+		snapshotTick = snapshotTicker.C
+	}
+	var savedKey snapshotKey
+	saveSnapshot := func(active []alarm.Alarm) {
+		savedKey = newSnapshotKey(actualSystemState, active)
+		if err := snapshots.Save(zoneSnapshot(actualSystemState, tempHistory, active, time.Now())); err != nil {
+			logger.Warn("state snapshot failed", "error", err)
+		}
+	}
+	if snapshots.Enabled() {
+		saveSnapshot(alarms.Active(zoneConfig.ID))
+	}
 
 loop:
 	for {
+		enforceManualLease(&actualSystemState, executor, threshold2, time.Now())
+		actualSystemState.RefreshDeviceStatus("esp32")
+		alarms.Evaluate(actualSystemState, time.Now())
+		publishedState = publishChanges(b, publishedState, actualSystemState)
+		if snapshots.Enabled() {
+			if active := alarms.Active(zoneConfig.ID); newSnapshotKey(actualSystemState, active) != savedKey {
+				saveSnapshot(active)
+			}
+		}
+
 		select {
-		case temp := <-ch.TempUpdatesChan:
-			esp32TimeoutTimer.Reset(esp32TimeoutTime)
+		case reading := <-in.temperature.C:
 			if !actualSystemState.DevicesOnline["esp32"] {
-				log.Println("INFO: Dispositivo ESP32 è ora ONLINE.")
+				logger.Info("device online", "device", "esp32")
 				actualSystemState.DevicesOnline["esp32"] = true
 			}
 
-			tempHistory = system.ManageTemperature(temp, tempHistory, &actualSystemState)
+			accepted, reason := sensors.Update(reading)
+			actualSystemState.Sensors = sensors.Snapshot()
+			actualSystemState.SensorFault = sensors.Fault()
+			system.LeaveFailSafe(&actualSystemState)
+			if !accepted {
+				logger.Warn("sensor reading rejected", "sensor", reading.SensorID, "value", reading.Value, "reason", reason)
+				if actualSystemState.SensorFault != "" {
+					system.ApplySensorFaultPolicy(&actualSystemState, sensors.FaultPolicy())
+				}
+				continue
+			}
+			fusedTemp, ok := sensors.Fused()
+			if !ok {
+				continue
+			}
+
+			tempHistory = system.ManageTemperature(fusedTemp, tempHistory, &actualSystemState)
 
-			system.ManageSystemLogic(
+			intervalChanged := system.ManageSystemLogic(
 				&actualSystemState,
 				threshold1, threshold2,
 				normalFreq, fastFreq,
-				ch.IntervalUpdatesChan,
 				&tooHotEnteredAt,
 				tooHotMaxDuration,
+				strategy,
+				reading.ReceivedAt,
 			)
+			if intervalChanged {
+				b.interval.Publish(actualSystemState.SamplingInterval)
+			}
+			if actualSystemState.SensorFault != "" {
+				system.ApplySensorFaultPolicy(&actualSystemState, sensors.FaultPolicy())
+			}
 
-		case stateRequest := <-ch.StateRequestChan:
-			stateRequest <- actualSystemState
+		case stateRequest := <-in.stateRequests.C:
+			stateRequest <- actualSystemState.Clone()
 
-		case commandRequest := <-ch.CommandRequestChan:
-			switch commandRequest {
-			case system.ToggleMode:
-				system.ToggleActualMode(&actualSystemState)
-			case system.OpenWindow:
-				if actualSystemState.OperativeMode == system.Manual {
-					windowManualCommand = system.CmdCloseWindow
-				} else {
-					windowManualCommand = system.NoCommand
-				}
-			case system.CloseWindow:
-				if actualSystemState.OperativeMode == system.Manual {
-					windowManualCommand = system.CmdCloseWindow
-				} else {
-					windowManualCommand = system.NoCommand
-				}
-			case system.ResetAlarm:
-				if actualSystemState.Status == system.Alarm {
-					if actualSystemState.CurrentTemp < threshold2 {
-						actualSystemState.Status = system.Normal
-					}
-				}
-			default:
-				log.Println("Comando sconosciuto")
-			}
+		case commandRequest := <-in.commands.C:
+			err := executor.execute(&actualSystemState, commandRequest, threshold2, time.Now())
+			commandRequest.Respond(err)
 
-		case data := <-ch.DataFromArduinoChan:
+		case data := <-in.fromArduino.C:
 			actualSystemState.WindowPosition = data.WindowPosition
+			actuator.Feedback(data.WindowPosition, time.Now())
+			actualSystemState.Actuator = actuator.Health
 			if data.ButtonPressed {
-				system.ToggleActualMode(&actualSystemState)
+				request := system.NewCommandRequest("", system.ToggleMode, system.SourceButton)
+				executor.execute(&actualSystemState, request, threshold2, time.Now())
 			}
-			actualSystemState.DevicesOnline["arduino"] = true
+			if !actualSystemState.DevicesOnline["arduino"] {
+				logger.Info("device online", "device", "arduino")
+				actualSystemState.DevicesOnline["arduino"] = true
+			}
+			arduinoLastSeen = time.Now()
 
 		case <-arduinoTimer.C:
 			arduinoTimer.Reset(arduinoSerialFreq)
 			if actualSystemState.DevicesOnline["arduino"] {
+				// in manuale la posizione viene controllata solo se e' stata richiesta una posizione precisa
+				target, supervised := actualSystemState.CommandWindowPosition, true
+				if actualSystemState.OperativeMode == system.Manual {
+					target, supervised = manualControl.Target, manualControl.HasTarget
+				}
+				windowPosition := actuator.Command(target, supervised, time.Now())
+				if actualSystemState.OperativeMode == system.Manual {
+					windowPosition = actualSystemState.CommandWindowPosition
+				}
+				actualSystemState.Actuator = actuator.Health
+
 				newData := arduinoserial.DataToArduino{
 					Temperature:          int(actualSystemState.CurrentTemp),
 					OperativeMode:        int(actualSystemState.OperativeMode),
-					WindowAction:         windowManualCommand,
+					WindowAction:         manualControl.NextCommand(actualSystemState.WindowPosition),
 					SystemState:          int(actualSystemState.Status),
-					SystemWindowPosition: actualSystemState.CommandWindowPosition,
-				}
-				windowManualCommand = 0
-				select {
-				case ch.DataToArduinoChan <- newData:
-				default:
-					<-ch.DataToArduinoChan
-					ch.DataToArduinoChan <- newData
-					log.Println("WARN: Buffer dati per Arduino pieno. sostituto valore.")
+					SystemWindowPosition: windowPosition,
 				}
+				b.toArduino.Publish(newData)
 			}
 
-		case <-esp32TimeoutTimer.C:
-			esp32TimeoutTimer.Reset(esp32TimeoutTime)
+		case now := <-scheduleTicker.C:
+			threshold1, threshold2 = applySchedule(&actualSystemState, zoneConfig, schedules, executor, now)
+
+		case now := <-sensorCheckTicker.C:
+			if actualSystemState.DevicesOnline["arduino"] && now.Sub(arduinoLastSeen) > arduinoTimeout {
+				logger.Warn("device offline", "device", "arduino")
+				actualSystemState.DevicesOnline["arduino"] = false
+			}
+			for _, id := range sensors.Expire(now) {
+				logger.Warn("sensor offline", "sensor", id)
+			}
+			actualSystemState.Sensors = sensors.Snapshot()
+			actualSystemState.SensorFault = sensors.Fault()
+			system.LeaveFailSafe(&actualSystemState)
+			if sensors.AnyOnline() {
+				break
+			}
 			if actualSystemState.DevicesOnline["esp32"] {
-				log.Println("ATTENZIONE: Dispositivo ESP32 è andato OFFLINE (timeout).")
+				logger.Warn("device offline", "device", "esp32")
 				actualSystemState.DevicesOnline["esp32"] = false
 			} else {
-				ch.IntervalUpdatesChan <- actualSystemState.SamplingInterval
+				// nessun sensore attivo: si ripete l'intervallo nel caso il sensore sia appena ripartito
+				b.interval.Publish(actualSystemState.SamplingInterval)
 			}
 
+		case <-snapshotTick:
+			saveSnapshot(alarms.Active(zoneConfig.ID))
+
 		case <-ctx.Done():
-			log.Println("System Manager : Shutdown")
+			if snapshots.Enabled() {
+				saveSnapshot(alarms.Active(zoneConfig.ID))
+			}
+			logger.Info("system manager stopped")
 			break loop
 		}
 	}
 }
 
+// publishChanges notifica gli eventi e il nuovo stato rispetto all'ultimo stato pubblicato.
+func publishChanges(b zoneBus, published, current system.SystemState) system.SystemState {
+	for _, event := range system.DiffEvents(published, current, time.Now()) {
+		system.CountEvent(event)
+		b.events.Publish(event)
+	}
+	system.UpdateMetrics(current)
+	snapshot := current.Clone()
+	b.state.Publish(snapshot)
+	return snapshot
+}
+
+// commandExecutor esegue i comandi di una zona, da qualunque sorgente provengano:
+// rinnova la lease manuale e registra il comando nell'audit log.
+type commandExecutor struct {
+	manualControl *system.ManualControl
+	manualLease   *system.ManualLease
+	auditLog      *audit.Log
+}
+
+func (e commandExecutor) execute(actualSystemState *system.SystemState, request system.CommandRequest, alarmThreshold float64, now time.Time) error {
+	before := actualSystemState.Clone()
+	err := system.ExecuteCommand(actualSystemState, request, alarmThreshold, e.manualControl)
+	if err != nil {
+		logger.Warn("command rejected", "zone", actualSystemState.Zone, "command", request.Type,
+			"source", request.Source, "user", request.User, "error", err)
+	} else {
+		e.manualLease.Update(actualSystemState, request.Source, now)
+	}
+	e.auditLog.Command(request, before, *actualSystemState, err, now)
+	return err
+}
+
+// applySchedule applica il calendario alla zona e restituisce le soglie da usare.
+// Il profilo vale finche' la voce e' attiva, mentre la modalita' viene impostata solo
+// quando cambiano le voci attive: l'operatore puo' sempre cambiarla a mano nel frattempo.
+// Quando finiscono le voci che avevano imposto la modalita' manuale la zona torna in
+// automatico, a meno che l'operatore non l'abbia nel frattempo presa in carico.
+func applySchedule(
+	actualSystemState *system.SystemState,
+	zoneConfig config.Zone,
+	schedules *schedule.Store,
+	executor commandExecutor,
+	now time.Time,
+) (threshold1, threshold2 float64) {
+	active := schedules.Active(zoneConfig.ID, now)
+
+	threshold1, threshold2 = zoneConfig.Threshold1, zoneConfig.Threshold2
+	actualSystemState.ForceWindowClosed = false
+	if profile := active.Profile; profile != nil {
+		threshold1, threshold2 = profile.Threshold1, profile.Threshold2
+		actualSystemState.ForceWindowClosed = profile.ForceWindowClosed
+	}
+
+	if !slices.Equal(active.EntryIDs, actualSystemState.ActiveSchedules) {
+		logger.Info("active schedules changed", "zone", zoneConfig.ID, "entries", active.EntryIDs,
+			"threshold1", threshold1, "threshold2", threshold2)
+		mode := active.Mode
+		if mode == nil && actualSystemState.OperativeMode == system.Manual && executor.manualLease.Scheduled() {
+			automatic := system.Automatic
+			mode = &automatic
+		}
+		if mode != nil {
+			request := system.NewCommandRequest("", system.SetMode, system.SourceScheduler)
+			request.Mode = *mode
+			executor.execute(actualSystemState, request, threshold2, now)
+		}
+		actualSystemState.ActiveSchedules = active.EntryIDs
+	}
+	return threshold1, threshold2
+}
+
+// enforceManualLease riporta la zona in automatico quando la lease manuale scade
+// o quando il sistema entra in allarme, e aggiorna il tempo rimanente nello stato.
+func enforceManualLease(
+	actualSystemState *system.SystemState,
+	executor commandExecutor,
+	alarmThreshold float64,
+	now time.Time,
+) {
+	manualLease := executor.manualLease
+	if actualSystemState.OperativeMode == system.Manual {
+		reason := ""
+		switch {
+		case actualSystemState.Status == system.Alarm:
+			reason = "alarm"
+		case manualLease.Expired(now):
+			reason = "manual lease expired"
+		}
+		if reason != "" {
+			logger.Info("returning to automatic mode", "zone", actualSystemState.Zone, "reason", reason)
+			request := system.NewCommandRequest("", system.SetMode, system.SourceSystem)
+			request.Mode = system.Automatic
+			executor.execute(actualSystemState, request, alarmThreshold, now)
+		}
+	}
+	manualLease.Refresh(actualSystemState, now)
+}
+
 func main() {
-	useMockApi := true
-	var wg sync.WaitGroup
-
-	startGoroutine := func(fn func()) {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			fn()
-		}()
-	}
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	go func() {
-		sigCh := make(chan os.Signal, 1)
-		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-		<-sigCh
-		cancel()
-	}()
-
-	// --- Canali ---
-	ch := Channels{
-		IntervalUpdatesChan: make(chan time.Duration),
-		TempUpdatesChan:     make(chan float64),
-		CommandRequestChan:  make(chan system.RequestType),
-		StateRequestChan:    make(chan chan system.SystemState),
-		DataFromArduinoChan: make(chan arduinoserial.DataFromArduino, 20),
-		DataToArduinoChan:   make(chan arduinoserial.DataToArduino, 1),
+	configPath := flag.String("config", "config.json", "percorso del file di configurazione")
+	mock := flag.Bool("mock", false, "modalita' demo: sensori e Arduino sostituiti da una stanza simulata")
+	flag.Parse()
+
+	if err := run(*configPath, *mock); err != nil {
+		logger.Error("fatal error", "error", err)
+		os.Exit(1)
 	}
+}
 
-	// --- MQTT ---
-	const broker = "tcp://localhost:1883"
-	const cliendID = "iot-server"
-	const tempTopic = "esp32/data/temperature"
+// run avvia tutti i servizi e attende il segnale di terminazione; restituisce un errore se
+// l'avvio fallisce. I servizi che si fermano in modo inatteso vengono riavviati dal supervisor.
+// Con mock i system manager vengono alimentati da una stanza simulata invece che dai
+// sensori MQTT e dalla seriale.
+func run(configPath string, mock bool) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return err
+	}
+	if err := logging.Setup(os.Stderr, cfg.Log.Format, cfg.Log.Level, cfg.Log.RateLimit.Std()); err != nil {
+		return err
+	}
 
-	var temperatureMessageHandler MQTT.MessageHandler = func(client MQTT.Client, msg MQTT.Message) {
-		temp, err := strconv.ParseFloat(string(msg.Payload()), 64)
-		if err == nil {
-			ch.TempUpdatesChan <- temp
-		} else {
-			log.Println("errore lettura temperatura")
+	zones := make([]*zone, 0, len(cfg.Zones))
+	zonesByID := make(map[string]*zone, len(cfg.Zones))
+	for i, zoneConfig := range cfg.Zones {
+		z, err := newZone(zoneConfig, i == 0, cfg.Sensor, cfg.Actuator)
+		if err != nil {
+			return fmt.Errorf("zona %s: %w", zoneConfig.ID, err)
 		}
+		zones = append(zones, z)
+		zonesByID[zoneConfig.ID] = z
 	}
 
-	client, err := mqtt.ConfigureClient(broker, cliendID,
+	schedules, err := schedule.Open(cfg.SchedulesPath)
+	if err != nil {
+		return err
+	}
+
+	alarms := alarm.NewManager(alarm.Config{
+		EscalateAfter: cfg.Alarms.EscalateAfter.Std(),
+		HistorySize:   cfg.Alarms.HistorySize,
+		OfflineDelay:  cfg.Alarms.OfflineDelay.Std(),
+	})
+	alarms.OnChange(func(c alarm.Change) {
+		a := c.Alarm
+		logger.Warn("alarm "+string(c.Kind), "zone", a.Zone, "id", a.ID, "type", a.Type,
+			"severity", a.Severity, "message", a.Message)
+	})
+
+	snapshots, err := snapshot.NewStore(cfg.State.Dir)
+	if err != nil {
+		return err
+	}
+	restorePolicy, err := snapshot.NewPolicy(cfg.State.RestoreAlarm, cfg.State.RestoreMode,
+		cfg.State.RestoreStatistics, cfg.State.MaxAge.Std())
+	if err != nil {
+		return err
+	}
+	// gli allarmi salvati vengono ripristinati prima di avviare le zone, cosi' che gli ID
+	// dei nuovi allarmi non si sovrappongano a quelli ripristinati
+	for _, z := range zones {
+		saved, ok, err := snapshots.Load(z.config.ID)
+		if err != nil {
+			logger.Warn("saved alarms not restored", "zone", z.config.ID, "error", err)
+			continue
+		}
+		if ok && len(saved.Alarms) > 0 {
+			alarms.Restore(saved.Alarms)
+			logger.Info("alarms restored from saved state", "zone", z.config.ID, "count", len(saved.Alarms))
+		}
+	}
+
+	dispatcher, err := newDispatcher(cfg.Notifications)
+	if err != nil {
+		return err
+	}
+	alarms.OnChange(dispatcher.HandleChange)
+
+	auditLog, err := audit.Open(cfg.Audit.Path, int64(cfg.Audit.MaxSizeMB)<<20, cfg.Audit.MaxFiles)
+	if err != nil {
+		return err
+	}
+	defer auditLog.Close()
+
+	var users *auth.Store
+	if cfg.API.Auth.Enabled {
+		var generated string
+		users, generated, err = auth.Open(cfg.API.Auth.UsersPath, cfg.API.Auth.SessionTTL.Std(), cfg.API.Auth.AdminPassword)
+		if err != nil {
+			return err
+		}
+		if generated != "" {
+			// la password viene mostrata solo qui e non finisce nei log strutturati
+			fmt.Fprintf(os.Stderr, "Creato l'utente admin con password %s: cambiarla al primo accesso.\n", generated)
+			logger.Warn("created admin user with generated password, printed to stderr")
+		}
+	} else {
+		logger.Warn("api authentication disabled")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// --- MQTT ---
+	outbox, err := mqtt.NewOutbox(cfg.MQTT.OutboxCapacity, cfg.MQTT.OutboxPath)
+	if err != nil {
+		return err
+	}
+	zoneCommands := func(zoneID string) (*bus.Topic[system.CommandRequest], bool) {
+		if zoneID == "" {
+			return zones[0].bus.commands, true
+		}
+		z, ok := zonesByID[zoneID]
+		if !ok {
+			return nil, false
+		}
+		return z.bus.commands, true
+	}
+	commandMessageHandler := mqtt.CommandMessageHandler(zoneCommands, outbox)
+
+	zoneTopics := make([]mqtt.ZoneTopics, 0, len(zones))
+	for _, z := range zones {
+		zoneTopics = append(zoneTopics, z.topics)
+	}
+
+	mqttOptions := mqtt.ClientOptions{
+		Broker:             cfg.MQTT.Broker,
+		ClientID:           cfg.MQTT.ClientID,
+		Username:           cfg.MQTT.Username,
+		Password:           cfg.MQTT.Password,
+		CAFile:             cfg.MQTT.CAFile,
+		CertFile:           cfg.MQTT.CertFile,
+		KeyFile:            cfg.MQTT.KeyFile,
+		ServerName:         cfg.MQTT.ServerName,
+		InsecureSkipVerify: cfg.MQTT.InsecureSkipVerify,
+		CleanSession:       cfg.MQTT.CleanSession,
+		KeepAlive:          cfg.MQTT.KeepAlive.Std(),
+		ConnectTimeout:     cfg.MQTT.ConnectTimeout.Std(),
+	}
+
+	// in modalita' demo le letture arrivano solo dalla stanza simulata
+	sensorZones := zones
+	if mock {
+		sensorZones = nil
+		logger.Warn("demo mode: sensors and arduino are simulated")
+	}
+	client, err := mqtt.ConfigureClient(mqttOptions,
 		func(c MQTT.Client) {
-			if token := c.Subscribe(tempTopic, 1, temperatureMessageHandler); token.Wait() && token.Error() != nil {
-				log.Printf("MQTT: errore nella risottoscrizione: %v", token.Error())
+			for _, z := range sensorZones {
+				handler := temperatureMessageHandler(z)
+				for _, topic := range z.topics.Sensors {
+					if token := c.Subscribe(topic, 1, handler); token.Wait() && token.Error() != nil {
+						logger.Error("mqtt subscribe failed", "topic", topic, "error", token.Error())
+					}
+				}
 			}
-		})
+			if token := c.Subscribe(mqtt.CommandTopic, 1, commandMessageHandler); token.Wait() && token.Error() != nil {
+				logger.Error("mqtt subscribe failed", "topic", mqtt.CommandTopic, "error", token.Error())
+			}
+		},
+		mqtt.HomeAssistantOnConnect(zoneTopics),
+	)
 
 	if err != nil {
-		log.Println(err)
-		goto Error
+		return fmt.Errorf("configurazione MQTT: %w", err)
 	}
 
-	startGoroutine(func() {
-		systemManager(ctx, ch)
+	// i servizi vengono fermati in ordine inverso: prima le API, poi le zone, infine code e notifiche
+	services := supervisor.New(supervisor.Config{
+		MinBackoff:  cfg.Supervisor.MinBackoff.Std(),
+		MaxBackoff:  cfg.Supervisor.MaxBackoff.Std(),
+		StopTimeout: cfg.Supervisor.StopTimeout.Std(),
+		ResetAfter:  cfg.Supervisor.ResetAfter.Std(),
+	})
+	services.Add(supervisor.Service{
+		Name: "mqtt-outbox",
+		Run:  func(ctx context.Context) error { outbox.Run(ctx, client); return nil },
+	})
+	services.Add(supervisor.Service{
+		Name: "notify",
+		Run:  func(ctx context.Context) error { dispatcher.Run(ctx); return nil },
 	})
+	if users != nil {
+		services.Add(supervisor.Service{
+			Name: "auth-sessions",
+			Run:  func(ctx context.Context) error { users.Run(ctx); return nil },
+		})
+	}
 
-	startGoroutine(func() { mqtt.MqttPublishInterval(ctx, client, ch.IntervalUpdatesChan) })
+	apiZones := make([]webserver.Zone, 0, len(zones))
+	for i, z := range zones {
+		manager := "manager:" + z.config.ID
+		// le sottoscrizioni sono create qui e non nei servizi, cosi' sopravvivono ai riavvii
+		intervalUpdates := z.bus.interval.Subscribe("mqtt", bus.Coalesce, 1)
+		stateUpdates := z.bus.state.Subscribe("mqtt", bus.Coalesce, 1)
+		events := z.bus.events.Subscribe("mqtt", bus.DropOldest, 20)
+		toArduino := z.bus.toArduino.Subscribe("arduino", bus.Coalesce, 1)
+		services.Add(supervisor.Service{
+			Name:      manager,
+			DependsOn: []string{"notify"},
+			Run: func(ctx context.Context) error {
+				systemManager(ctx, z.config, z.bus, z.inputs, z.sensors, z.actuator, z.strategy, schedules, alarms, auditLog,
+					snapshots, restorePolicy, cfg.State.Interval.Std())
+				return nil
+			},
+			Ready: managerReady(z.bus),
+		})
+		services.Add(supervisor.Service{
+			Name:      "mqtt-interval:" + z.config.ID,
+			DependsOn: []string{"mqtt-outbox", manager},
+			Run: func(ctx context.Context) error {
+				mqtt.MqttPublishInterval(ctx, outbox, z.topics.Interval, intervalUpdates.C)
+				return nil
+			},
+		})
+		services.Add(supervisor.Service{
+			Name:      "mqtt-state:" + z.config.ID,
+			DependsOn: []string{"mqtt-outbox", manager},
+			Run: func(ctx context.Context) error {
+				mqtt.MqttPublishState(ctx, outbox, z.topics, stateUpdates.C, events.C)
+				return nil
+			},
+		})
+		if mock {
+			services.Add(simulatedDevices(z, i, manager, toArduino))
+		} else {
+			services.Add(supervisor.Service{
+				Name:      "arduino:" + z.config.ID,
+				DependsOn: []string{manager},
+				Run: func(ctx context.Context) error {
+					return arduinoserial.ManageArduino(ctx, z.config.ID, z.config.SerialPort, z.bus.fromArduino, toArduino.C)
+				},
+			})
+		}
 
-	startGoroutine(func() { webserver.ApiServer(ctx, useMockApi, ch.CommandRequestChan, ch.StateRequestChan) })
+		apiZones = append(apiZones, webserver.Zone{
+			ID:            z.config.ID,
+			Name:          z.config.Name,
+			Commands:      z.bus.commands,
+			StateRequests: z.bus.stateRequests,
+		})
+	}
 
-	startGoroutine(func() { arduinoserial.ManageArduino(ctx, ch.DataFromArduinoChan, ch.DataToArduinoChan) })
+	apiConfig := webserver.Config{
+		Zones:          apiZones,
+		Schedules:      schedules,
+		Alarms:         alarms,
+		Auth:           users,
+		Audit:          auditLog,
+		AllowedOrigins: cfg.API.AllowedOrigins,
+		SecureCookies:  cfg.API.Auth.SecureCookies,
+		Services:       services.Status,
+	}
+	// in modalita' demo i dati non arrivano dal broker, che puo' anche mancare
+	if !mock {
+		apiConfig.MQTTConnected = client.IsConnectionOpen
+	}
+	// se la porta non e' disponibile il server viene riavviato con backoff. Non dipende dai
+	// system manager: /healthz e /metrics devono rispondere proprio quando una zona non parte,
+	// mentre le richieste alle zone hanno gia' un timeout.
+	services.Add(supervisor.Service{
+		Name:    "api",
+		Restart: supervisor.OnFailure,
+		Run:     func(ctx context.Context) error { return webserver.ApiServer(ctx, apiConfig) },
+	})
 
-	log.Println("INFO: Tutti i servizi sono stati avviati.")
+	if err := services.Start(); err != nil {
+		return err
+	}
+	logger.Info("all services started", "zones", len(zones))
 
 	<-ctx.Done()
-	log.Println("Shutdown in corso")
-	wg.Wait()
-	log.Println("Shutdown completato.")
+	logger.Info("shutting down")
+	services.Stop()
+	mqtt.Disconnect(client)
+	logger.Info("shutdown complete")
+	return nil
+}
 
-Error:
+// managerReady restituisce quando il system manager della zona risponde a una richiesta di
+// stato, cioe' ha ripristinato lo stato salvato ed e' entrato nel ciclo principale.
+func managerReady(b zoneBus) func(context.Context) error {
+	return func(ctx context.Context) error {
+		reply := make(chan system.SystemState, 1)
+		if err := b.stateRequests.PublishContext(ctx, reply); err != nil {
+			return err
+		}
+		select {
+		case <-reply:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// inoltra le letture dei sensori di una zona al suo system manager
+func temperatureMessageHandler(z *zone) MQTT.MessageHandler {
+	return func(client MQTT.Client, msg MQTT.Message) {
+		mqtt.MessagesReceived.With("temperature").Inc()
+		sensorID := mqtt.SensorIDFromTopic(msg.Topic(), z.topics.DefaultSensorID)
+		reading, err := mqtt.ParseTemperaturePayload(msg.Payload(), sensorID, time.Now())
+		if err == nil {
+			// non blocca la callback di paho: con il manager in ritardo si perdono le letture piu' vecchie
+			z.bus.temperature.Publish(reading)
+		} else {
+			mqtt.ParseErrors.With("temperature").Inc()
+			logger.Warn("invalid temperature payload", "zone", z.config.ID, "topic", msg.Topic(), "error", err)
+		}
+	}
 }
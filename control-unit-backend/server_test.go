@@ -0,0 +1,60 @@
+package main
+
+import (
+	"server/config"
+	"server/schedule"
+	"server/system"
+	"testing"
+	"time"
+)
+
+func TestScheduledManualModeRevertsWhenEntryEnds(t *testing.T) {
+	schedules, err := schedule.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := schedules.Put(schedule.Entry{ID: "manutenzione", Start: "08:00", End: "09:00", Mode: "manual"}); err != nil {
+		t.Fatal(err)
+	}
+	zoneConfig := config.Zone{ID: "serra", Threshold1: 25, Threshold2: 30}
+	lease := system.ManualLease{Duration: 10 * time.Minute}
+	executor := commandExecutor{manualControl: &system.ManualControl{}, manualLease: &lease}
+	state := system.SystemState{Zone: "serra", OperativeMode: system.Automatic}
+	at := func(clock string) time.Time {
+		t, _ := time.Parse(time.DateTime, "2025-07-01 "+clock+":00")
+		return t
+	}
+	step := func(now time.Time) {
+		applySchedule(&state, zoneConfig, schedules, executor, now)
+		enforceManualLease(&state, executor, zoneConfig.Threshold2, now)
+	}
+
+	step(at("08:00"))
+	if state.OperativeMode != system.Manual {
+		t.Fatal("la voce del calendario non ha impostato la modalita' manuale")
+	}
+	step(at("08:59"))
+	if state.OperativeMode != system.Manual {
+		t.Fatal("la modalita' manuale del calendario e' scaduta prima della fine della voce")
+	}
+	step(at("09:00"))
+	if state.OperativeMode != system.Automatic {
+		t.Fatal("la zona resta in manuale dopo la fine della voce del calendario")
+	}
+
+	// se l'operatore prende in carico la zona vale la sua lease, non la fine della voce
+	step(at("08:00").AddDate(0, 0, 1))
+	request := system.NewCommandRequest("", system.SetWindowPosition, system.SourceHTTP)
+	request.Position = 40
+	if err := executor.execute(&state, request, zoneConfig.Threshold2, at("08:55").AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	step(at("09:00").AddDate(0, 0, 1))
+	if state.OperativeMode != system.Manual {
+		t.Fatal("la fine della voce ha annullato la presa in carico dell'operatore")
+	}
+	step(at("09:05").AddDate(0, 0, 1))
+	if state.OperativeMode != system.Automatic {
+		t.Fatal("la lease dell'operatore non e' scaduta")
+	}
+}
@@ -0,0 +1,69 @@
+package sim
+
+import (
+	"server/clock"
+	"server/system"
+	"time"
+)
+
+// Parametri della logica di una zona usati nella simulazione ad anello chiuso.
+type ZoneParams struct {
+	Threshold1        float64
+	Threshold2        float64
+	NormalFreq        time.Duration
+	FastFreq          time.Duration
+	TooHotMaxDuration time.Duration
+}
+
+type Sample struct {
+	Elapsed     time.Duration
+	Temperature float64
+	Measured    float64
+	Outside     float64
+	Command     system.Degree
+	Position    float64
+	Status      string
+}
+
+// RunClosedLoop accoppia la stanza simulata alla logica reale del sistema (ManageTemperature,
+// ManageSystemLogic e la strategia della finestra) per duration di tempo simulato.
+// Il sensore campiona con l'intervallo deciso dal sistema e il servo riceve CommandWindowPosition.
+func RunClosedLoop(room *Room, zone ZoneParams, strategy system.WindowStrategy, clk *clock.Fake, duration time.Duration, sample func(Sample)) {
+	start := clk.Now()
+	state := system.SystemState{
+		Status:           system.Normal,
+		SamplingInterval: zone.NormalFreq,
+		OperativeMode:    system.Automatic,
+	}
+	tempHistory := make([]float64, 0, system.MaxTemperatureBuffer)
+	var tooHotEnteredAt time.Time
+
+	for elapsed := time.Duration(0); elapsed < duration; elapsed = clk.Now().Sub(start) {
+		dt := state.SamplingInterval
+		room.Step(dt, state.CommandWindowPosition, clk.Now())
+		clk.Advance(dt)
+
+		measured := room.Measure()
+		tempHistory = system.ManageTemperature(measured, tempHistory, &state)
+		system.ManageSystemLogic(
+			&state,
+			zone.Threshold1, zone.Threshold2,
+			zone.NormalFreq, zone.FastFreq,
+			&tooHotEnteredAt,
+			zone.TooHotMaxDuration,
+			strategy,
+			clk.Now(),
+		)
+		state.WindowPosition = system.Degree(room.WindowPosition)
+
+		sample(Sample{
+			Elapsed:     clk.Now().Sub(start),
+			Temperature: room.Temperature,
+			Measured:    measured,
+			Outside:     room.OutsideTemperature(clk.Now()),
+			Command:     state.CommandWindowPosition,
+			Position:    room.WindowPosition,
+			Status:      state.Status.String(),
+		})
+	}
+}
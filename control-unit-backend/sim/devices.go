@@ -0,0 +1,94 @@
+package sim
+
+import (
+	"context"
+	"math"
+	"server/arduinoserial"
+	"server/system"
+	"time"
+)
+
+// passo di un comando manuale di apertura o chiusura, come nel firmware di Arduino
+const manualStep = 5
+
+// Devices sostituisce in tempo reale il sensore e Arduino di una zona con la stanza simulata,
+// cosi' che il system manager giri esattamente come in produzione senza hardware.
+type Devices struct {
+	Room           *Room
+	SensorID       string
+	SampleInterval time.Duration // intervallo iniziale del sensore, finche' il sistema non ne invia un altro
+	Step           time.Duration // passo del modello e periodo dei dati inviati da Arduino
+
+	commanded   system.Degree
+	lastCommand int
+}
+
+// actuate applica i dati inviati ad Arduino come il firmware: in automatico il servo va a
+// SystemWindowPosition, in manuale si muove di un passo ad ogni cambio del comando.
+func (d *Devices) actuate(data arduinoserial.DataToArduino) {
+	if system.OperativeMode(data.OperativeMode) != system.Manual {
+		d.commanded = data.SystemWindowPosition
+		d.lastCommand = data.WindowAction
+		return
+	}
+	if data.WindowAction != d.lastCommand {
+		position := system.Degree(math.Round(d.Room.WindowPosition))
+		switch data.WindowAction {
+		case system.CmdOpenWindow:
+			d.commanded = min(position+manualStep, system.MaxWindowPosition)
+		case system.CmdCloseWindow:
+			d.commanded = max(position-manualStep, system.MinWindowPosition)
+		}
+		d.lastCommand = data.WindowAction
+	}
+}
+
+// Run fa evolvere la stanza finche' ctx non viene cancellato. Le letture del sensore vengono
+// passate a reading con l'intervallo ricevuto su interval, la posizione della finestra a
+// fromArduino ad ogni passo; toArduino riceve i comandi del system manager.
+func (d *Devices) Run(
+	ctx context.Context,
+	interval <-chan time.Duration,
+	toArduino <-chan arduinoserial.DataToArduino,
+	reading func(system.TemperatureReading),
+	fromArduino func(arduinoserial.DataFromArduino),
+) {
+	stepTicker := time.NewTicker(d.Step)
+	defer stepTicker.Stop()
+	sampleTicker := time.NewTicker(d.SampleInterval)
+	defer sampleTicker.Stop()
+
+	lastStep := time.Now()
+	var seq uint64
+	for {
+		select {
+		case now := <-stepTicker.C:
+			d.Room.Step(now.Sub(lastStep), d.commanded, now)
+			lastStep = now
+			fromArduino(arduinoserial.DataFromArduino{WindowPosition: system.Degree(math.Round(d.Room.WindowPosition))})
+
+		case now := <-sampleTicker.C:
+			seq++
+			reading(system.TemperatureReading{
+				SensorID:   d.SensorID,
+				Value:      d.Room.Measure(),
+				Unit:       "C",
+				Timestamp:  now,
+				Seq:        seq,
+				HasSeq:     true,
+				ReceivedAt: now,
+			})
+
+		case newInterval := <-interval:
+			if newInterval > 0 {
+				sampleTicker.Reset(newInterval)
+			}
+
+		case data := <-toArduino:
+			d.actuate(data)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
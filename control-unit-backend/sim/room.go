@@ -0,0 +1,77 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+	"server/system"
+	"time"
+)
+
+// Parametri del modello termico a un nodo della stanza:
+//
+//	dT/dt = HeatGain - (LossClosed + LossOpen*apertura) * (T - Toutside)
+//
+// con apertura in [0,1] e temperatura esterna sinusoidale nell'arco della giornata.
+type RoomParams struct {
+	InitialTemp      float64
+	OutsideTemp      float64 // temperatura esterna media
+	OutsideAmplitude float64 // escursione giornaliera (picco alle 15)
+	HeatGain         float64 // °C/s dovuti a persone, macchine, sole
+	LossClosed       float64 // 1/s con finestra chiusa
+	LossOpen         float64 // 1/s aggiuntivi con finestra completamente aperta
+	WindowSpeed      float64 // gradi/s del servo
+	SensorNoise      float64 // deviazione standard del rumore di misura
+	Seed             int64
+}
+
+func DefaultRoomParams() RoomParams {
+	return RoomParams{
+		InitialTemp:      22,
+		OutsideTemp:      18,
+		OutsideAmplitude: 6,
+		HeatGain:         0.01,
+		LossClosed:       0.0002,
+		LossOpen:         0.003,
+		WindowSpeed:      30,
+		SensorNoise:      0.05,
+		Seed:             1,
+	}
+}
+
+type Room struct {
+	Params         RoomParams
+	Temperature    float64
+	WindowPosition float64
+	rng            *rand.Rand
+}
+
+func NewRoom(params RoomParams) *Room {
+	return &Room{
+		Params:      params,
+		Temperature: params.InitialTemp,
+		rng:         rand.New(rand.NewSource(params.Seed)),
+	}
+}
+
+func (r *Room) OutsideTemperature(now time.Time) float64 {
+	hours := float64(now.Hour()) + float64(now.Minute())/60 + float64(now.Second())/3600
+	return r.Params.OutsideTemp + r.Params.OutsideAmplitude*math.Cos((hours-15)/24*2*math.Pi)
+}
+
+// Step fa avanzare il modello di dt: la finestra si muove verso commanded alla velocita' del servo
+// e la temperatura evolve con l'apertura risultante.
+func (r *Room) Step(dt time.Duration, commanded system.Degree, now time.Time) {
+	seconds := dt.Seconds()
+	target := float64(min(max(commanded, system.MinWindowPosition), system.MaxWindowPosition))
+	maxStep := r.Params.WindowSpeed * seconds
+	r.WindowPosition += min(max(target-r.WindowPosition, -maxStep), maxStep)
+
+	opening := r.WindowPosition / float64(system.MaxWindowPosition)
+	loss := r.Params.LossClosed + r.Params.LossOpen*opening
+	r.Temperature += (r.Params.HeatGain - loss*(r.Temperature-r.OutsideTemperature(now))) * seconds
+}
+
+// Measure restituisce la temperatura letta dal sensore, con rumore gaussiano.
+func (r *Room) Measure() float64 {
+	return r.Temperature + r.rng.NormFloat64()*r.Params.SensorNoise
+}
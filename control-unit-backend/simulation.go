@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"server/arduinoserial"
+	"server/bus"
+	"server/sim"
+	"server/supervisor"
+	"time"
+)
+
+// periodo del modello della stanza e dei dati inviati dall'Arduino simulato
+const simulationStep = 250 * time.Millisecond
+
+// simulatedDevices sostituisce il sensore e l'Arduino della zona con una stanza simulata
+// collegata agli stessi topic del bus, in modo che il system manager non veda differenze.
+func simulatedDevices(z *zone, index int, manager string, toArduino *bus.Subscription[arduinoserial.DataToArduino]) supervisor.Service {
+	params := sim.DefaultRoomParams()
+	params.Seed += int64(index) // ogni zona ha il proprio rumore di misura
+	devices := &sim.Devices{
+		Room:           sim.NewRoom(params),
+		SensorID:       z.topics.DefaultSensorID,
+		SampleInterval: z.config.NormalFreq.Std(),
+		Step:           simulationStep,
+	}
+	interval := z.bus.interval.Subscribe("sim", bus.Coalesce, 1)
+	return supervisor.Service{
+		Name:      "sim:" + z.config.ID,
+		DependsOn: []string{manager},
+		Run: func(ctx context.Context) error {
+			devices.Run(ctx, interval.C, toArduino.C, z.bus.temperature.Publish, z.bus.fromArduino.Publish)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,182 @@
+// Package snapshot salva su disco lo stato di esecuzione di ogni zona, cosi' che dopo
+// un riavvio il system manager riparta dalla modalita', dall'allarme e dalle statistiche
+// in cui si trovava invece che da Automatic/Normal.
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"server/alarm"
+	"server/system"
+	"strings"
+	"time"
+)
+
+// Finestra delle ultime temperature da cui sono calcolate media, minimo e massimo.
+// MinTemp e MaxTemp hanno senso solo se History non e' vuota.
+type Statistics struct {
+	History     []float64
+	CurrentTemp float64
+	AverageTemp float64
+	MinTemp     float64
+	MaxTemp     float64
+}
+
+// Stato salvato di una zona.
+type Zone struct {
+	Zone                 string
+	SavedAt              time.Time
+	Status               system.SystemStatus
+	OperativeMode        system.OperativeMode
+	ManualLeaseExpiresAt time.Time     `json:",omitzero"`  // zero: la modalita' manuale non scade
+	Alarms               []alarm.Alarm `json:",omitempty"` // allarmi attivi o riconosciuti della zona
+	Statistics           Statistics
+}
+
+// Politica di ripristino dell'allarme.
+type AlarmPolicy string
+
+const (
+	AlarmAlways AlarmPolicy = "always" // rientra in Alarm anche se lo stato salvato e' piu' vecchio di MaxAge
+	AlarmRecent AlarmPolicy = "recent" // rientra in Alarm solo se lo stato salvato e' entro MaxAge
+	AlarmNever  AlarmPolicy = "never"
+)
+
+// Politica di ripristino della modalita' operativa.
+type ModePolicy string
+
+const (
+	ModeRestore   ModePolicy = "restore"   // ripristina la modalita' manuale se la lease non e' scaduta
+	ModeAutomatic ModePolicy = "automatic" // riparte sempre in automatico
+)
+
+type Policy struct {
+	Alarm      AlarmPolicy
+	Mode       ModePolicy
+	Statistics bool
+	// oltre questa eta' modalita' e statistiche salvate vengono ignorate; 0 nessun limite
+	MaxAge time.Duration
+}
+
+// NewPolicy verifica i nomi delle politiche letti dalla configurazione; i valori vuoti
+// valgono "always" e "restore".
+func NewPolicy(alarmPolicy, modePolicy string, statistics bool, maxAge time.Duration) (Policy, error) {
+	p := Policy{
+		Alarm:      AlarmPolicy(strings.ToLower(alarmPolicy)),
+		Mode:       ModePolicy(strings.ToLower(modePolicy)),
+		Statistics: statistics,
+		MaxAge:     maxAge,
+	}
+	switch p.Alarm {
+	case "":
+		p.Alarm = AlarmAlways
+	case AlarmAlways, AlarmRecent, AlarmNever:
+	default:
+		return p, fmt.Errorf("politica di ripristino dell'allarme non valida: %q", alarmPolicy)
+	}
+	switch p.Mode {
+	case "":
+		p.Mode = ModeRestore
+	case ModeRestore, ModeAutomatic:
+	default:
+		return p, fmt.Errorf("politica di ripristino della modalita' non valida: %q", modePolicy)
+	}
+	return p, nil
+}
+
+// Fresh indica se lo stato salvato e' abbastanza recente da essere ripristinato.
+func (p Policy) Fresh(z Zone, now time.Time) bool {
+	return p.MaxAge <= 0 || now.Sub(z.SavedAt) <= p.MaxAge
+}
+
+// RestoreAlarm indica se la zona deve rientrare in Alarm.
+func (p Policy) RestoreAlarm(z Zone, now time.Time) bool {
+	if z.Status != system.Alarm {
+		return false
+	}
+	switch p.Alarm {
+	case AlarmAlways:
+		return true
+	case AlarmRecent:
+		return p.Fresh(z, now)
+	default:
+		return false
+	}
+}
+
+// Store legge e scrive un file JSON per zona nella directory dir.
+// Con dir vuota il salvataggio e' disabilitato.
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if dir == "" {
+		return &Store{}, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("errore creazione directory dello stato: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+func (s *Store) Enabled() bool { return s.dir != "" }
+
+func (s *Store) path(zone string) string {
+	return filepath.Join(s.dir, zone+".json")
+}
+
+// Load restituisce lo stato salvato della zona; ok e' false se non ne esiste uno.
+func (s *Store) Load(zone string) (z Zone, ok bool, err error) {
+	if s.dir == "" {
+		return Zone{}, false, nil
+	}
+	data, err := os.ReadFile(s.path(zone))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Zone{}, false, nil
+	}
+	if err != nil {
+		return Zone{}, false, fmt.Errorf("errore lettura stato della zona %s: %w", zone, err)
+	}
+	if err := json.Unmarshal(data, &z); err != nil {
+		return Zone{}, false, fmt.Errorf("stato della zona %s non valido: %w", zone, err)
+	}
+	return z, true, nil
+}
+
+// Save scrive lo stato della zona con scrittura atomica: un riavvio durante il
+// salvataggio lascia il file precedente intatto.
+func (s *Store) Save(z Zone) error {
+	if s.dir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(z, "", "  ")
+	if err != nil {
+		return fmt.Errorf("errore salvataggio stato della zona %s: %w", z.Zone, err)
+	}
+	path := s.path(z.Zone)
+	tmp, err := os.CreateTemp(s.dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("errore salvataggio stato della zona %s: %w", z.Zone, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("errore salvataggio stato della zona %s: %w", z.Zone, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("errore salvataggio stato della zona %s: %w", z.Zone, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("errore salvataggio stato della zona %s: %w", z.Zone, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("errore salvataggio stato della zona %s: %w", z.Zone, err)
+	}
+	return nil
+}
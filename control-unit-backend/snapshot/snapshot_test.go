@@ -0,0 +1,118 @@
+package snapshot_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"server/alarm"
+	"server/snapshot"
+	"server/system"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+
+func TestSaveAndLoadRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store, err := snapshot.NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := snapshot.Zone{
+		Zone:                 "serra",
+		SavedAt:              start,
+		Status:               system.Alarm,
+		OperativeMode:        system.Manual,
+		ManualLeaseExpiresAt: start.Add(20 * time.Minute),
+		Alarms: []alarm.Alarm{{
+			ID: "3", Zone: "serra", Type: alarm.OverTemperature, Severity: alarm.Critical, InitialSeverity: alarm.Major,
+			State: alarm.Acknowledged, RaisedAt: start.Add(-time.Hour), AcknowledgedAt: start.Add(-time.Minute), AcknowledgedBy: "mario",
+		}},
+		Statistics: snapshot.Statistics{History: []float64{21.5, 22, 22.5}, CurrentTemp: 22.5, AverageTemp: 22, MinTemp: 21.5, MaxTemp: 22.5},
+	}
+	if err := store.Save(saved); err != nil {
+		t.Fatal(err)
+	}
+	// una seconda scrittura sostituisce la prima senza lasciare file temporanei
+	saved.Statistics.CurrentTemp = 23
+	if err := store.Save(saved); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 || entries[0].Name() != "serra.json" {
+		t.Fatalf("file nella directory dello stato: %v", entries)
+	}
+
+	loaded, ok, err := store.Load("serra")
+	if err != nil || !ok {
+		t.Fatalf("caricamento: %v %v", ok, err)
+	}
+	if !reflect.DeepEqual(loaded, saved) {
+		t.Fatalf("stato caricato\n%+v\natteso\n%+v", loaded, saved)
+	}
+
+	if _, ok, err := store.Load("ufficio"); ok || err != nil {
+		t.Fatalf("zona mai salvata: %v %v", ok, err)
+	}
+}
+
+func TestLoadReportsCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := snapshot.NewStore(dir)
+	os.WriteFile(filepath.Join(dir, "serra.json"), []byte(`{"Zone": "serra", `), 0o644)
+	if _, ok, err := store.Load("serra"); ok || err == nil {
+		t.Fatalf("file troncato accettato: %v %v", ok, err)
+	}
+}
+
+func TestDisabledStoreDoesNothing(t *testing.T) {
+	store, err := snapshot.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Enabled() {
+		t.Fatal("store senza directory abilitato")
+	}
+	if err := store.Save(snapshot.Zone{Zone: "serra"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := store.Load("serra"); ok || err != nil {
+		t.Fatalf("caricamento da uno store disabilitato: %v %v", ok, err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	if _, err := snapshot.NewPolicy("sometimes", "", false, 0); err == nil {
+		t.Error("politica dell'allarme non valida accettata")
+	}
+	if _, err := snapshot.NewPolicy("", "manual", false, 0); err == nil {
+		t.Error("politica della modalita' non valida accettata")
+	}
+	p, err := snapshot.NewPolicy("", "", true, time.Hour)
+	if err != nil || p.Alarm != snapshot.AlarmAlways || p.Mode != snapshot.ModeRestore {
+		t.Fatalf("politica di default %+v %v", p, err)
+	}
+
+	old := snapshot.Zone{Status: system.Alarm, SavedAt: start.Add(-2 * time.Hour)}
+	recent := snapshot.Zone{Status: system.Alarm, SavedAt: start.Add(-time.Minute)}
+	normal := snapshot.Zone{Status: system.Hot, SavedAt: start}
+	for _, c := range []struct {
+		alarm string
+		zone  snapshot.Zone
+		want  bool
+	}{
+		{"always", old, true},
+		{"recent", old, false},
+		{"RECENT", recent, true},
+		{"never", recent, false},
+		{"always", normal, false},
+	} {
+		p, _ := snapshot.NewPolicy(c.alarm, "", false, time.Hour)
+		if got := p.RestoreAlarm(c.zone, start); got != c.want {
+			t.Errorf("%s con stato salvato alle %v: %v, atteso %v", c.alarm, c.zone.SavedAt.Format(time.TimeOnly), got, c.want)
+		}
+	}
+	if p, _ := snapshot.NewPolicy("", "", false, 0); !p.Fresh(old, start) {
+		t.Error("senza MaxAge lo stato salvato deve essere sempre recente")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"server/alarm"
+	"server/snapshot"
+	"server/system"
+	"strings"
+	"time"
+)
+
+// Parte dello stato che, se cambia, viene salvata subito invece che al salvataggio periodico.
+type snapshotKey struct {
+	status         system.SystemStatus
+	mode           system.OperativeMode
+	leaseExpiresAt time.Time
+	alarms         string
+}
+
+func newSnapshotKey(actualSystemState system.SystemState, active []alarm.Alarm) snapshotKey {
+	var alarms strings.Builder
+	for _, a := range active {
+		fmt.Fprintf(&alarms, "%s:%s:%d:%d;", a.ID, a.State, a.Severity, a.ShelvedUntil.Unix())
+	}
+	return snapshotKey{
+		status:         actualSystemState.Status,
+		mode:           actualSystemState.OperativeMode,
+		leaseExpiresAt: actualSystemState.ManualLeaseExpiresAt,
+		alarms:         alarms.String(),
+	}
+}
+
+func zoneSnapshot(actualSystemState system.SystemState, tempHistory []float64, active []alarm.Alarm, now time.Time) snapshot.Zone {
+	saved := snapshot.Zone{
+		Zone:                 actualSystemState.Zone,
+		SavedAt:              now,
+		Status:               actualSystemState.Status,
+		OperativeMode:        actualSystemState.OperativeMode,
+		ManualLeaseExpiresAt: actualSystemState.ManualLeaseExpiresAt,
+		Alarms:               active,
+	}
+	// senza letture minimo e massimo non sono definiti
+	if len(tempHistory) > 0 && actualSystemState.MinTemp != nil && actualSystemState.MaxTemp != nil {
+		saved.Statistics = snapshot.Statistics{
+			History:     tempHistory,
+			CurrentTemp: actualSystemState.CurrentTemp,
+			AverageTemp: actualSystemState.AverageTemp,
+			MinTemp:     *actualSystemState.MinTemp,
+			MaxTemp:     *actualSystemState.MaxTemp,
+		}
+	}
+	return saved
+}
+
+// restoreSnapshot applica allo stato iniziale della zona lo stato salvato prima del riavvio,
+// secondo la politica configurata, e restituisce la finestra delle temperature ripristinata.
+func restoreSnapshot(
+	saved snapshot.Zone,
+	policy snapshot.Policy,
+	actualSystemState *system.SystemState,
+	tempHistory []float64,
+	manualLease *system.ManualLease,
+	fastFreq time.Duration,
+	now time.Time,
+) []float64 {
+	logger := logger.With("zone", actualSystemState.Zone, "saved_at", saved.SavedAt)
+	fresh := policy.Fresh(saved, now)
+	if !fresh {
+		logger.Info("saved state too old, mode and statistics not restored", "max_age", policy.MaxAge)
+	}
+
+	if policy.RestoreAlarm(saved, now) {
+		actualSystemState.Status = system.Alarm
+		actualSystemState.StatusString = system.Alarm.String()
+		actualSystemState.SamplingInterval = fastFreq
+		actualSystemState.CommandWindowPosition = system.MaxWindowPosition
+		logger.Warn("alarm restored from saved state")
+	}
+
+	// in allarme la zona torna comunque in automatico
+	if fresh && policy.Mode == snapshot.ModeRestore && saved.OperativeMode == system.Manual &&
+		actualSystemState.Status != system.Alarm {
+		expiresAt := saved.ManualLeaseExpiresAt
+		switch {
+		case expiresAt.IsZero() && manualLease.Duration > 0:
+			// modalita' impostata dal calendario: viene riapplicata se la voce e' ancora attiva
+		case !expiresAt.IsZero() && !now.Before(expiresAt):
+			logger.Info("manual lease expired while stopped, starting in automatic mode")
+		default:
+			actualSystemState.OperativeMode = system.Manual
+			actualSystemState.OperativeModeString = system.Manual.String()
+			manualLease.Restore(expiresAt)
+			manualLease.Refresh(actualSystemState, now)
+			logger.Info("manual mode restored from saved state", "lease_expires_at", expiresAt)
+		}
+	}
+
+	if stats := saved.Statistics; fresh && policy.Statistics && len(stats.History) > 0 {
+		history := stats.History[max(len(stats.History)-system.MaxTemperatureBuffer, 0):]
+		tempHistory = append(tempHistory[:0], history...)
+		actualSystemState.CurrentTemp = stats.CurrentTemp
+		actualSystemState.AverageTemp = stats.AverageTemp
+		actualSystemState.MinTemp = &stats.MinTemp
+		actualSystemState.MaxTemp = &stats.MaxTemp
+		logger.Info("statistics restored from saved state", "samples", len(tempHistory))
+	}
+	return tempHistory
+}
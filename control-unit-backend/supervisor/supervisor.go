@@ -0,0 +1,364 @@
+// Package supervisor avvia e sorveglia le goroutine di lunga durata del server.
+//
+// Ogni servizio viene riavviato secondo la propria politica con backoff esponenziale;
+// un panic viene recuperato e trattato come un errore, registrando lo stack. Un servizio
+// parte solo quando tutte le sue dipendenze sono pronte; i servizi vengono fermati
+// in ordine inverso, attendendo per ciascuno al massimo il proprio StopTimeout.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"server/logging"
+	"slices"
+	"sync"
+	"time"
+)
+
+var logger = logging.For("supervisor")
+
+type Policy string
+
+const (
+	Always    Policy = "always"     // riavvia anche quando il servizio termina senza errori
+	OnFailure Policy = "on-failure" // riavvia solo dopo un errore o un panic
+)
+
+type State string
+
+const (
+	Pending    State = "pending"
+	Running    State = "running"
+	Restarting State = "restarting" // in attesa del backoff dopo una terminazione
+	Completed  State = "completed"  // terminato senza errori con politica OnFailure
+	Stopped    State = "stopped"
+)
+
+type Service struct {
+	Name        string
+	Run         func(ctx context.Context) error // deve restituire quando ctx viene cancellato
+	Restart     Policy
+	DependsOn   []string
+	StopTimeout time.Duration // 0: quello di Config
+	// opzionale: chiamata dopo il primo avvio, restituisce quando il servizio puo' essere usato
+	// dai dipendenti; senza Ready il servizio e' pronto appena parte
+	Ready func(ctx context.Context) error
+}
+
+// valori usati quando MinBackoff o MaxBackoff non sono impostati: un backoff nullo
+// riavvierebbe di continuo un servizio che fallisce subito
+const (
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+type Config struct {
+	MinBackoff  time.Duration // 0: defaultMinBackoff
+	MaxBackoff  time.Duration // 0: defaultMaxBackoff
+	StopTimeout time.Duration
+	// un servizio rimasto in esecuzione almeno per questo tempo riparte dal backoff minimo
+	ResetAfter time.Duration
+}
+
+// terminazioni consecutive dopo cui un servizio che viene riavviato non e' piu' considerato
+// in un problema transitorio
+const failingAfter = 3
+
+// Stato di un servizio, esposto dall'endpoint di salute.
+type Status struct {
+	Name      string
+	State     State
+	Ready     bool // le dipendenze del servizio possono partire
+	Restarts  int
+	Failures  int // terminazioni consecutive, azzerate come il backoff dopo ResetAfter
+	Panics    int
+	LastError string    `json:",omitempty"`
+	StartedAt time.Time `json:",omitzero"`
+	DependsOn []string  `json:",omitempty"`
+}
+
+// OK indica se il servizio sta svolgendo il suo compito.
+func (s Status) OK() bool {
+	return s.State == Running || s.State == Completed
+}
+
+// Failing indica se il servizio continua a terminare subito dopo ogni riavvio;
+// un singolo riavvio in attesa del backoff non conta.
+func (s Status) Failing() bool {
+	return s.State == Restarting && s.Failures >= failingAfter
+}
+
+type entry struct {
+	service      Service
+	dependencies []*entry
+	cancel       context.CancelFunc
+	done         chan struct{}
+	ready        chan struct{} // chiuso quando il servizio e' pronto per i dipendenti
+
+	mu     sync.Mutex
+	status Status
+}
+
+func (e *entry) update(fn func(*Status)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fn(&e.status)
+}
+
+type Supervisor struct {
+	config Config
+
+	mu       sync.Mutex
+	entries  []*entry // in ordine di avvio dopo Start
+	started  bool
+	stopping bool
+}
+
+func New(config Config) *Supervisor {
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = defaultMinBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaultMaxBackoff
+	}
+	config.MaxBackoff = max(config.MaxBackoff, config.MinBackoff)
+	return &Supervisor{config: config}
+}
+
+// Add registra un servizio; va chiamata prima di Start.
+func (s *Supervisor) Add(service Service) {
+	if service.Restart == "" {
+		service.Restart = Always
+	}
+	if service.StopTimeout <= 0 {
+		service.StopTimeout = s.config.StopTimeout
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, &entry{
+		service: service,
+		done:    make(chan struct{}),
+		ready:   make(chan struct{}),
+		status:  Status{Name: service.Name, State: Pending, DependsOn: service.DependsOn},
+	})
+}
+
+// order restituisce i servizi in ordine di dipendenza, mantenendo l'ordine di
+// registrazione tra servizi indipendenti.
+func order(entries []*entry) ([]*entry, error) {
+	byName := make(map[string]*entry, len(entries))
+	for _, e := range entries {
+		if _, ok := byName[e.service.Name]; ok {
+			return nil, fmt.Errorf("servizio %q registrato due volte", e.service.Name)
+		}
+		byName[e.service.Name] = e
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[string]int, len(entries))
+	sorted := make([]*entry, 0, len(entries))
+	var visit func(e *entry) error
+	visit = func(e *entry) error {
+		switch marks[e.service.Name] {
+		case visiting:
+			return fmt.Errorf("dipendenza circolare sul servizio %q", e.service.Name)
+		case visited:
+			return nil
+		}
+		marks[e.service.Name] = visiting
+		e.dependencies = e.dependencies[:0]
+		for _, dep := range e.service.DependsOn {
+			d, ok := byName[dep]
+			if !ok {
+				return fmt.Errorf("il servizio %q dipende da %q, non registrato", e.service.Name, dep)
+			}
+			if err := visit(d); err != nil {
+				return err
+			}
+			e.dependencies = append(e.dependencies, d)
+		}
+		marks[e.service.Name] = visited
+		sorted = append(sorted, e)
+		return nil
+	}
+	for _, e := range entries {
+		if err := visit(e); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// Start avvia i servizi in ordine di dipendenza: ogni servizio resta Pending finche' le sue
+// dipendenze non sono pronte. I servizi non dipendono dal context del chiamante: vengono
+// fermati solo da Stop, nell'ordine inverso.
+func (s *Supervisor) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return errors.New("supervisor gia' avviato")
+	}
+	sorted, err := order(s.entries)
+	if err != nil {
+		return err
+	}
+	s.entries, s.started = sorted, true
+	for _, e := range s.entries {
+		ctx, cancel := context.WithCancel(context.Background())
+		e.cancel = cancel
+		go s.supervise(ctx, e)
+	}
+	logger.Info("services started", "count", len(s.entries))
+	return nil
+}
+
+// markReady segnala ai dipendenti che il servizio e' pronto, subito o quando Ready restituisce.
+func markReady(ctx context.Context, e *entry) {
+	ready := func() {
+		e.update(func(st *Status) { st.Ready = true })
+		close(e.ready)
+	}
+	if e.service.Ready == nil {
+		ready()
+		return
+	}
+	go func() {
+		if err := e.service.Ready(ctx); err != nil {
+			if ctx.Err() == nil {
+				logger.Error("service readiness failed", "service", e.service.Name, "error", err)
+			}
+			return
+		}
+		logger.Debug("service ready", "service", e.service.Name)
+		ready()
+	}()
+}
+
+// runOnce esegue il servizio una volta trasformando un panic in errore.
+func runOnce(ctx context.Context, e *entry) (panicked bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked, err = true, fmt.Errorf("panic: %v", r)
+			logger.Error("service panicked", "service", e.service.Name, "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	return false, e.service.Run(ctx)
+}
+
+// waitDependencies attende che tutte le dipendenze del servizio siano pronte;
+// restituisce false se il servizio viene fermato prima.
+func waitDependencies(ctx context.Context, e *entry) bool {
+	for _, dep := range e.dependencies {
+		select {
+		case <-dep.ready:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Supervisor) supervise(ctx context.Context, e *entry) {
+	defer close(e.done)
+	if !waitDependencies(ctx, e) {
+		e.update(func(st *Status) { st.State = Stopped })
+		return
+	}
+	backoff := s.config.MinBackoff
+	for first := true; ; first = false {
+		started := time.Now()
+		e.update(func(st *Status) { st.State, st.StartedAt = Running, started })
+		if first {
+			markReady(ctx, e)
+		}
+		panicked, err := runOnce(ctx, e)
+
+		if ctx.Err() != nil {
+			e.update(func(st *Status) { st.State = Stopped })
+			return
+		}
+		if err == nil && e.service.Restart == OnFailure {
+			logger.Info("service completed", "service", e.service.Name)
+			e.update(func(st *Status) { st.State = Completed })
+			return
+		}
+
+		stable := s.config.ResetAfter > 0 && time.Since(started) >= s.config.ResetAfter
+		if stable {
+			backoff = s.config.MinBackoff
+		}
+		e.update(func(st *Status) {
+			if stable {
+				st.Failures = 0
+			}
+			st.State = Restarting
+			st.Restarts++
+			st.Failures++
+			if panicked {
+				st.Panics++
+			}
+			if err != nil {
+				st.LastError = err.Error()
+			}
+		})
+		if err != nil {
+			logger.Error("service failed, restarting", "service", e.service.Name, "error", err, "retry_in", backoff)
+		} else {
+			logger.Warn("service exited, restarting", "service", e.service.Name, "retry_in", backoff)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			e.update(func(st *Status) { st.State = Stopped })
+			return
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, s.config.MaxBackoff)
+	}
+}
+
+// Stop ferma i servizi in ordine inverso di avvio; per ognuno attende al massimo StopTimeout.
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	if !s.started || s.stopping {
+		s.mu.Unlock()
+		return
+	}
+	s.stopping = true
+	entries := slices.Clone(s.entries)
+	s.mu.Unlock()
+
+	for _, e := range slices.Backward(entries) {
+		e.cancel()
+		timer := time.NewTimer(e.service.StopTimeout)
+		select {
+		case <-e.done:
+			logger.Debug("service stopped", "service", e.service.Name)
+		case <-timer.C:
+			logger.Warn("service did not stop in time", "service", e.service.Name, "timeout", e.service.StopTimeout)
+		}
+		timer.Stop()
+	}
+}
+
+// Status restituisce lo stato dei servizi in ordine di avvio.
+func (s *Supervisor) Status() []Status {
+	s.mu.Lock()
+	entries := slices.Clone(s.entries)
+	s.mu.Unlock()
+	statuses := make([]Status, 0, len(entries))
+	for _, e := range entries {
+		e.mu.Lock()
+		status := e.status
+		e.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -0,0 +1,174 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStartWaitsForDependencies(t *testing.T) {
+	s := New(Config{StopTimeout: time.Second})
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var started []string
+	record := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		started = append(started, name)
+	}
+	s.Add(Service{Name: "api", DependsOn: []string{"manager"}, Run: func(ctx context.Context) error {
+		record("api")
+		<-ctx.Done()
+		return nil
+	}})
+	s.Add(Service{Name: "manager", DependsOn: []string{"queue"}, Run: func(ctx context.Context) error {
+		record("manager")
+		<-ctx.Done()
+		return nil
+	}})
+	s.Add(Service{
+		Name: "queue",
+		Run:  func(ctx context.Context) error { <-ctx.Done(); return nil },
+		// la dipendenza e' in esecuzione ma non ancora pronta: i dipendenti restano in attesa
+		Ready: func(ctx context.Context) error {
+			<-release
+			record("queue")
+			return nil
+		},
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	waitFor(t, func() bool { return s.Status()[0].State == Running }, "la dipendenza non e' partita")
+	time.Sleep(50 * time.Millisecond)
+	for _, st := range s.Status()[1:] {
+		if st.State != Pending {
+			t.Fatalf("%s in stato %s prima che la dipendenza sia pronta", st.Name, st.State)
+		}
+	}
+	close(release)
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(started) == 3
+	}, "i servizi non sono partiti")
+	mu.Lock()
+	defer mu.Unlock()
+	if started[0] != "queue" || started[1] != "manager" || started[2] != "api" {
+		t.Fatalf("ordine di avvio %v", started)
+	}
+}
+
+func TestZeroBackoffDefaultsToMinimum(t *testing.T) {
+	s := New(Config{StopTimeout: time.Second})
+	var runs atomic.Int32
+	s.Add(Service{Name: "failing", Run: func(ctx context.Context) error {
+		runs.Add(1)
+		return errors.New("errore")
+	}})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	s.Stop()
+	if n := runs.Load(); n != 1 {
+		t.Fatalf("servizio riavviato %d volte in 200ms con backoff non configurato", n-1)
+	}
+	if st := s.Status()[0]; st.State != Stopped || st.Restarts != 1 || st.LastError != "errore" {
+		t.Fatalf("stato %+v", st)
+	}
+}
+
+func TestRestartsWithBackoffAndRecoversPanics(t *testing.T) {
+	s := New(Config{MinBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond, StopTimeout: time.Second})
+	var runs atomic.Int32
+	s.Add(Service{Name: "flaky", Run: func(ctx context.Context) error {
+		if runs.Add(1) < 3 {
+			panic("guasto")
+		}
+		<-ctx.Done()
+		return nil
+	}})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return s.Status()[0].State == Running && runs.Load() == 3 }, "servizio non ripartito")
+	s.Stop()
+	if st := s.Status()[0]; st.Panics != 2 || st.Restarts != 2 || st.State != Stopped {
+		t.Fatalf("stato %+v", st)
+	}
+}
+
+func TestStartRejectsDependencyCycles(t *testing.T) {
+	s := New(Config{})
+	run := func(ctx context.Context) error { <-ctx.Done(); return nil }
+	s.Add(Service{Name: "a", DependsOn: []string{"b"}, Run: run})
+	s.Add(Service{Name: "b", DependsOn: []string{"a"}, Run: run})
+	if err := s.Start(); err == nil {
+		s.Stop()
+		t.Fatal("dipendenza circolare accettata")
+	}
+}
+
+func TestFailingOnlyAfterRepeatedFailures(t *testing.T) {
+	// il backoff lungo tiene il servizio in Restarting dopo la terminazione
+	s := New(Config{MinBackoff: time.Hour, StopTimeout: time.Second})
+	s.Add(Service{Name: "failing", Run: func(ctx context.Context) error { return errors.New("errore") }})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	waitFor(t, func() bool { return s.Status()[0].State == Restarting }, "servizio non terminato")
+	if st := s.Status()[0]; st.Failing() {
+		t.Fatalf("un singolo riavvio e' considerato un guasto: %+v", st)
+	}
+
+	st := Status{State: Restarting, Failures: failingAfter}
+	if !st.Failing() {
+		t.Fatalf("servizio che continua a fallire non segnalato: %+v", st)
+	}
+	st.State = Running
+	if st.Failing() {
+		t.Fatal("servizio ripartito ancora segnalato come guasto")
+	}
+}
+
+func TestFailuresResetAfterStableRun(t *testing.T) {
+	s := New(Config{MinBackoff: time.Millisecond, StopTimeout: time.Second, ResetAfter: 20 * time.Millisecond})
+	var runs atomic.Int32
+	s.Add(Service{Name: "flaky", Run: func(ctx context.Context) error {
+		// due terminazioni immediate, poi un'esecuzione lunga prima del guasto successivo
+		if runs.Add(1) == 3 {
+			time.Sleep(30 * time.Millisecond)
+		}
+		if runs.Load() > 3 {
+			<-ctx.Done()
+			return nil
+		}
+		return errors.New("errore")
+	}})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return runs.Load() == 4 }, "servizio non ripartito")
+	s.Stop()
+	if st := s.Status()[0]; st.Restarts != 3 || st.Failures != 1 {
+		t.Fatalf("stato %+v", st)
+	}
+}
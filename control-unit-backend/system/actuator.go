@@ -0,0 +1,147 @@
+package system
+
+import (
+	"time"
+)
+
+type ActuatorStatus string
+
+const (
+	ActuatorIdle     ActuatorStatus = "idle"
+	ActuatorMoving   ActuatorStatus = "moving"
+	ActuatorRetrying ActuatorStatus = "retrying"
+	ActuatorFault    ActuatorStatus = "fault"
+)
+
+// dopo questo tempo senza variazioni di posizione la finestra si considera ferma
+const actuatorStillAfter = 2 * time.Second
+
+type ActuatorConfig struct {
+	Timeout    time.Duration // tempo concesso per raggiungere la posizione comandata
+	Tolerance  Degree
+	MaxRetries int
+}
+
+// Stato di salute e statistiche di movimento dell'attuatore della finestra.
+type ActuatorHealth struct {
+	Status         ActuatorStatus
+	Target         Degree
+	Retries        int
+	Faults         int       // numero di fault dall'avvio
+	FaultSince     time.Time `json:",omitzero"`
+	Movements      int
+	MovementsToday int
+	TotalDegrees   int
+	Day            string // giorno a cui si riferisce MovementsToday
+}
+
+// ActuatorSupervisor confronta la posizione comandata con quella riportata da Arduino.
+// Se la differenza supera la tolleranza per piu' di Timeout il comando viene ripetuto
+// fermando la finestra per un ciclo; esauriti i tentativi l'attuatore va in fault.
+// Un nuovo comando, oltre la tolleranza dal precedente, riparte con tempo e tentativi pieni.
+// Come SensorSet va usato solo dalla goroutine del system manager.
+type ActuatorSupervisor struct {
+	Config ActuatorConfig
+	Health ActuatorHealth
+
+	feedback      Degree
+	hasFeedback   bool
+	moving        bool
+	lastMove      time.Time
+	mismatchSince time.Time
+	// posizione comandata a cui si riferiscono mismatchSince e Health.Retries
+	mismatchTarget Degree
+}
+
+func NewActuatorSupervisor(config ActuatorConfig) *ActuatorSupervisor {
+	return &ActuatorSupervisor{Config: config, Health: ActuatorHealth{Status: ActuatorIdle}}
+}
+
+func absDegree(d Degree) Degree {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Feedback registra la posizione riportata da Arduino e aggiorna le statistiche di movimento.
+func (a *ActuatorSupervisor) Feedback(position Degree, now time.Time) {
+	if day := now.Format(time.DateOnly); day != a.Health.Day {
+		a.Health.Day = day
+		a.Health.MovementsToday = 0
+	}
+	if !a.hasFeedback {
+		a.feedback, a.hasFeedback = position, true
+		return
+	}
+	delta := absDegree(position - a.feedback)
+	if delta == 0 {
+		if a.moving && now.Sub(a.lastMove) > actuatorStillAfter {
+			a.moving = false
+		}
+		return
+	}
+	if !a.moving {
+		a.moving = true
+		a.Health.Movements++
+		a.Health.MovementsToday++
+	}
+	a.Health.TotalDegrees += int(delta)
+	a.lastMove = now
+	a.feedback = position
+}
+
+// Command restituisce la posizione da inviare ad Arduino per target. Con supervised false
+// (ad esempio in manuale senza una posizione richiesta) il confronto viene sospeso.
+func (a *ActuatorSupervisor) Command(target Degree, supervised bool, now time.Time) Degree {
+	a.Health.Target = target
+	if !supervised || !a.hasFeedback {
+		a.mismatchSince = time.Time{}
+		if a.Health.Status != ActuatorFault {
+			a.Health.Status = ActuatorIdle
+		}
+		return target
+	}
+
+	if !a.mismatchSince.IsZero() && absDegree(target-a.mismatchTarget) > a.Config.Tolerance {
+		a.mismatchSince = time.Time{}
+		a.Health.Retries = 0
+	}
+
+	if absDegree(a.feedback-target) <= a.Config.Tolerance {
+		if a.Health.Status == ActuatorFault {
+			logger.Info("window actuator recovered", "position", a.feedback)
+		}
+		a.Health.Status = ActuatorIdle
+		a.Health.Retries = 0
+		a.Health.FaultSince = time.Time{}
+		a.mismatchSince = time.Time{}
+		return target
+	}
+
+	if a.mismatchSince.IsZero() {
+		a.mismatchSince, a.mismatchTarget = now, target
+		if a.Health.Status != ActuatorFault {
+			a.Health.Status = ActuatorMoving
+		}
+	}
+	if a.Health.Status == ActuatorFault || now.Sub(a.mismatchSince) < a.Config.Timeout {
+		return target
+	}
+
+	if a.Health.Retries < a.Config.MaxRetries {
+		a.Health.Retries++
+		a.Health.Status = ActuatorRetrying
+		a.mismatchSince = now
+		logger.Warn("window did not reach target, retrying", "target", target, "position", a.feedback,
+			"retry", a.Health.Retries, "max_retries", a.Config.MaxRetries)
+		// per un ciclo la finestra viene fermata nella posizione attuale, poi il comando viene ripetuto
+		return a.feedback
+	}
+
+	a.Health.Status = ActuatorFault
+	a.Health.Faults++
+	a.Health.FaultSince = now
+	logger.Error("window actuator fault", "target", target, "position", a.feedback)
+	return target
+}
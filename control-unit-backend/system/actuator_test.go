@@ -0,0 +1,57 @@
+package system_test
+
+import (
+	"server/system"
+	"testing"
+	"time"
+)
+
+func newActuator() *system.ActuatorSupervisor {
+	return system.NewActuatorSupervisor(system.ActuatorConfig{Timeout: 10 * time.Second, Tolerance: 3, MaxRetries: 2})
+}
+
+func TestActuatorRetriesThenFaults(t *testing.T) {
+	a := newActuator()
+	now := start
+	a.Feedback(0, now)
+	a.Command(90, true, now)
+
+	// la finestra non si muove: due tentativi, poi fault
+	for retry := 1; retry <= 2; retry++ {
+		now = now.Add(11 * time.Second)
+		if cmd := a.Command(90, true, now); cmd != 0 || a.Health.Retries != retry {
+			t.Fatalf("tentativo %d: comando %d, stato %+v", retry, cmd, a.Health)
+		}
+	}
+	now = now.Add(11 * time.Second)
+	a.Command(90, true, now)
+	if a.Health.Status != system.ActuatorFault {
+		t.Fatalf("stato %s invece di fault", a.Health.Status)
+	}
+}
+
+func TestActuatorNewTargetRestartsTimeoutAndRetries(t *testing.T) {
+	a := newActuator()
+	now := start
+	a.Feedback(0, now)
+	a.Command(90, true, now)
+	now = now.Add(11 * time.Second)
+	a.Command(90, true, now)
+	if a.Health.Retries != 1 {
+		t.Fatalf("tentativi %d", a.Health.Retries)
+	}
+
+	// un nuovo comando azzera i tentativi e concede di nuovo tutto il Timeout
+	a.Command(40, true, now.Add(time.Second))
+	if a.Health.Retries != 0 || a.Health.Status != system.ActuatorMoving {
+		t.Fatalf("stato dopo il nuovo comando %+v", a.Health)
+	}
+	if cmd := a.Command(40, true, now.Add(9*time.Second)); cmd != 40 || a.Health.Retries != 0 {
+		t.Fatalf("ripetizione prima del Timeout del nuovo comando: comando %d, stato %+v", cmd, a.Health)
+	}
+
+	// una variazione entro la tolleranza non riavvia il conteggio
+	if cmd := a.Command(42, true, now.Add(12*time.Second)); cmd != 0 || a.Health.Retries != 1 {
+		t.Fatalf("comando %d, stato %+v", cmd, a.Health)
+	}
+}
@@ -0,0 +1,100 @@
+package system
+
+import (
+	"errors"
+)
+
+type CommandSource string
+
+const (
+	SourceHTTP      CommandSource = "http"
+	SourceMQTT      CommandSource = "mqtt"
+	SourceButton    CommandSource = "arduino-button"
+	SourceScheduler CommandSource = "scheduler"
+	SourceSystem    CommandSource = "system" // azioni automatiche del system manager
+)
+
+var (
+	ErrNotManualMode      = errors.New("comando disponibile solo in modalità manuale")
+	ErrNotInAlarm         = errors.New("il sistema non è in allarme")
+	ErrTemperatureTooHigh = errors.New("temperatura ancora sopra la soglia di allarme")
+	ErrUnknownCommand     = errors.New("comando sconosciuto")
+	ErrInvalidMode        = errors.New("modalità operativa non valida")
+)
+
+// Richiesta di comando verso il system manager. Reply, se presente, deve essere
+// bufferizzato: il manager non resta mai in attesa del lettore.
+type CommandRequest struct {
+	ID       string
+	Type     RequestType
+	Position Degree        // solo per SetWindowPosition
+	Mode     OperativeMode // solo per SetMode
+	Source   CommandSource
+	User     string // utente autenticato, per i comandi via HTTP
+	Reply    chan error
+}
+
+func NewCommandRequest(id string, requestType RequestType, source CommandSource) CommandRequest {
+	return CommandRequest{
+		ID:     id,
+		Type:   requestType,
+		Source: source,
+		Reply:  make(chan error, 1),
+	}
+}
+
+func (r CommandRequest) Respond(err error) {
+	if r.Reply == nil {
+		return
+	}
+	select {
+	case r.Reply <- err:
+	default:
+	}
+}
+
+// ExecuteCommand applica un comando dell'operatore allo stato del sistema.
+// I comandi della finestra aggiornano manual, da cui vengono poi generati i comandi per Arduino.
+func ExecuteCommand(actualSystemState *SystemState, request CommandRequest, alarmThreshold float64, manual *ManualControl) error {
+	switch request.Type {
+	case ToggleMode:
+		ToggleActualMode(actualSystemState)
+		manual.Clear()
+	case SetMode:
+		if request.Mode != Manual && request.Mode != Automatic {
+			return ErrInvalidMode
+		}
+		if actualSystemState.OperativeMode != request.Mode {
+			ToggleActualMode(actualSystemState)
+			manual.Clear()
+		}
+	case OpenWindow, CloseWindow, SetWindowPosition:
+		if actualSystemState.OperativeMode != Manual {
+			manual.Clear()
+			return ErrNotManualMode
+		}
+		switch request.Type {
+		case OpenWindow:
+			manual.Clear()
+			manual.Command = CmdOpenWindow
+		case CloseWindow:
+			manual.Clear()
+			manual.Command = CmdCloseWindow
+		default:
+			manual.SetTarget(request.Position)
+		}
+	case ResetAlarm:
+		if actualSystemState.Status != Alarm {
+			return ErrNotInAlarm
+		}
+		if actualSystemState.CurrentTemp >= alarmThreshold {
+			return ErrTemperatureTooHigh
+		}
+		actualSystemState.Status = Normal
+		actualSystemState.StatusString = actualSystemState.Status.String()
+		logger.Info("alarm reset", "zone", actualSystemState.Zone, "source", request.Source)
+	default:
+		return ErrUnknownCommand
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package system
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	MinWindowPosition Degree = 0
+	MaxWindowPosition Degree = 90
+)
+
+// Strategia di controllo della finestra usata negli stati Normal e Hot;
+// in Too_hot e Alarm la finestra viene comunque aperta completamente.
+type WindowStrategy interface {
+	Position(actualSystemState SystemState, now time.Time) Degree
+	Reset()
+}
+
+func clampPosition(position float64) Degree {
+	if math.IsNaN(position) {
+		return MinWindowPosition
+	}
+	return Degree(min(max(position, float64(MinWindowPosition)), float64(MaxWindowPosition)))
+}
+
+// Mappatura lineare storica: finestra chiusa sotto threshold1, apertura proporzionale in Hot.
+// Usa le soglie presenti nello stato, che possono cambiare con i profili del calendario.
+type LinearStrategy struct{}
+
+func (l *LinearStrategy) Position(actualSystemState SystemState, now time.Time) Degree {
+	if actualSystemState.Status != Hot {
+		return MinWindowPosition
+	}
+	t1, t2 := actualSystemState.Threshold1, actualSystemState.Threshold2
+	return clampPosition((actualSystemState.CurrentTemp - t1) * (t2 / (t2 - t1)))
+}
+
+func (l *LinearStrategy) Reset() {}
+
+// passo massimo di integrazione del PID: una pausa piu' lunga tra due letture
+// (sensore assente) non deve far saltare integrale e derivata
+const pidMaxStep = 5 * time.Second
+
+// Controllore PID che porta la temperatura verso Setpoint aprendo la finestra.
+// L'integrale viene aggiornato solo se l'uscita non e' limitata (anti-windup)
+// e la variazione dell'apertura e' limitata a MaxRate gradi al secondo.
+type PIDStrategy struct {
+	Setpoint float64
+	Kp       float64
+	Ki       float64
+	Kd       float64
+	MaxRate  float64 // gradi/s, 0 disabilita il limite
+
+	integral    float64
+	prevError   float64
+	output      float64
+	lastUpdate  time.Time
+	initialized bool
+}
+
+func (p *PIDStrategy) Position(actualSystemState SystemState, now time.Time) Degree {
+	err := actualSystemState.CurrentTemp - p.Setpoint
+	if !p.initialized {
+		p.initialized = true
+		p.prevError = err
+		p.lastUpdate = now
+		// si riparte dalla posizione comandata in precedenza, cosi' che il limite di
+		// velocita' valga anche al rientro da Too_hot, Alarm o dalla modalita' manuale
+		p.output = clampValue(float64(actualSystemState.CommandWindowPosition))
+		if p.MaxRate <= 0 {
+			p.output = clampValue(p.Kp * err)
+		}
+		return clampPosition(p.output)
+	}
+
+	dt := min(now.Sub(p.lastUpdate), pidMaxStep).Seconds()
+	if dt <= 0 {
+		return clampPosition(p.output)
+	}
+	p.lastUpdate = now
+
+	derivative := (err - p.prevError) / dt
+	p.prevError = err
+
+	candidateIntegral := p.integral + err*dt
+	raw := p.Kp*err + p.Ki*candidateIntegral + p.Kd*derivative
+	target := p.limit(raw, dt)
+	// anti-windup: l'integrale non cresce se l'uscita e' limitata nella stessa direzione dell'errore
+	if !(raw > target && err > 0) && !(raw < target && err < 0) {
+		p.integral = candidateIntegral
+	}
+	p.output = target
+	return clampPosition(p.output)
+}
+
+// limit applica la saturazione 0-90 e il limite di velocita' del servo
+func (p *PIDStrategy) limit(v float64, dt float64) float64 {
+	v = clampValue(v)
+	if p.MaxRate > 0 {
+		maxStep := p.MaxRate * dt
+		v = min(max(v, p.output-maxStep), p.output+maxStep)
+	}
+	return v
+}
+
+func (p *PIDStrategy) Reset() {
+	p.integral = 0
+	p.prevError = 0
+	p.output = 0
+	p.initialized = false
+}
+
+func clampValue(v float64) float64 {
+	return min(max(v, float64(MinWindowPosition)), float64(MaxWindowPosition))
+}
+
+type StrategyConfig struct {
+	Name     string // "linear" o "pid"
+	Setpoint float64
+	Kp       float64
+	Ki       float64
+	Kd       float64
+	MaxRate  float64
+}
+
+func NewWindowStrategy(config StrategyConfig) (WindowStrategy, error) {
+	switch strings.ToLower(config.Name) {
+	case "", "linear":
+		return &LinearStrategy{}, nil
+	case "pid":
+		return &PIDStrategy{
+			Setpoint: config.Setpoint,
+			Kp:       config.Kp,
+			Ki:       config.Ki,
+			Kd:       config.Kd,
+			MaxRate:  config.MaxRate,
+		}, nil
+	default:
+		return nil, fmt.Errorf("strategia di controllo non valida: %q", config.Name)
+	}
+}
@@ -0,0 +1,133 @@
+package system_test
+
+import (
+	"math"
+	"server/clock"
+	"server/sim"
+	"server/system"
+	"testing"
+	"time"
+)
+
+const setpoint = 24.0
+
+// stanza con temperatura esterna costante sotto il setpoint: a finestra chiusa si
+// scalda fino a 65°C, completamente aperta si ferma intorno ai 18°C
+func testRoom(initial float64) *sim.Room {
+	return sim.NewRoom(sim.RoomParams{
+		InitialTemp: initial,
+		OutsideTemp: 15,
+		HeatGain:    0.01,
+		LossClosed:  0.0002,
+		LossOpen:    0.003,
+		WindowSpeed: 30,
+		Seed:        1,
+	})
+}
+
+// soglie alte, cosi' che la finestra resti sempre affidata alla strategia
+var testZone = sim.ZoneParams{
+	Threshold1:        80,
+	Threshold2:        90,
+	NormalFreq:        500 * time.Millisecond,
+	FastFreq:          500 * time.Millisecond,
+	TooHotMaxDuration: time.Minute,
+}
+
+// guadagni adatti alla stanza di prova, molto lenta: 1°C di errore apre la finestra di 80°
+func newPID() *system.PIDStrategy {
+	return &system.PIDStrategy{Setpoint: setpoint, Kp: 80, Ki: 0.2, MaxRate: 10}
+}
+
+var start = time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+
+func TestPIDSettlesOnSetpointWithoutOvershoot(t *testing.T) {
+	var samples []sim.Sample
+	sim.RunClosedLoop(testRoom(22), testZone, newPID(), clock.NewFake(start), 2*time.Hour, func(s sim.Sample) {
+		samples = append(samples, s)
+	})
+
+	maxTemp := math.Inf(-1)
+	for _, s := range samples {
+		maxTemp = max(maxTemp, s.Temperature)
+	}
+	if overshoot := maxTemp - setpoint; overshoot > 0.5 {
+		t.Errorf("sovraelongazione di %.2f°C oltre il setpoint", overshoot)
+	}
+
+	// nell'ultima mezz'ora la temperatura resta vicina al setpoint
+	for _, s := range samples {
+		if s.Elapsed < 90*time.Minute {
+			continue
+		}
+		if math.Abs(s.Temperature-setpoint) > 0.3 {
+			t.Fatalf("a %v la temperatura e' %.2f°C, lontana dal setpoint", s.Elapsed, s.Temperature)
+		}
+	}
+}
+
+func TestPIDDoesNotWindUpWhileSaturated(t *testing.T) {
+	room := testRoom(40)
+	// un apporto di calore che nemmeno la finestra completamente aperta compensa
+	room.Params.HeatGain = 0.1
+	var after []sim.Sample
+	sim.RunClosedLoop(room, testZone, newPID(), clock.NewFake(start), 3*time.Hour, func(s sim.Sample) {
+		if s.Elapsed < time.Hour {
+			if s.Elapsed > 10*time.Minute && s.Command != system.MaxWindowPosition {
+				t.Fatalf("a %v la finestra e' a %d° invece che completamente aperta", s.Elapsed, s.Command)
+			}
+			return
+		}
+		room.Params.HeatGain = 0.01
+		after = append(after, s)
+	})
+
+	// con l'integrale cresciuto durante la saturazione la finestra resterebbe aperta
+	// troppo a lungo e la stanza scenderebbe ben sotto il setpoint
+	minTemp := math.Inf(1)
+	for _, s := range after {
+		minTemp = min(minTemp, s.Temperature)
+	}
+	if undershoot := setpoint - minTemp; undershoot > 0.3 {
+		t.Errorf("la temperatura scende di %.2f°C sotto il setpoint dopo la saturazione", undershoot)
+	}
+	if last := after[len(after)-1]; math.Abs(last.Temperature-setpoint) > 0.3 {
+		t.Errorf("a fine simulazione la temperatura e' %.2f°C", last.Temperature)
+	}
+}
+
+func TestPIDRespectsRateLimit(t *testing.T) {
+	pid := newPID()
+	var prev *sim.Sample
+	// la stanza parte a 40°C: la strategia chiede subito la massima apertura
+	sim.RunClosedLoop(testRoom(40), testZone, pid, clock.NewFake(start), 10*time.Minute, func(s sim.Sample) {
+		if prev != nil {
+			dt := (s.Elapsed - prev.Elapsed).Seconds()
+			// la posizione comandata e' arrotondata al grado
+			if step := math.Abs(float64(s.Command - prev.Command)); step > pid.MaxRate*dt+1 {
+				t.Fatalf("a %v la finestra si muove di %.0f° in %.1fs", s.Elapsed, step, dt)
+			}
+		}
+		prev = &s
+	})
+}
+
+func TestPIDRestartsFromCommandedPosition(t *testing.T) {
+	pid := newPID()
+	now := start
+	pid.Position(system.SystemState{CurrentTemp: 30}, now)
+	// la zona passa in Too_hot: la finestra viene aperta completamente senza la strategia
+	pid.Reset()
+
+	// al rientro sotto il setpoint la finestra si richiude alla velocita' massima, senza scatti
+	now = now.Add(time.Hour)
+	state := system.SystemState{CurrentTemp: setpoint - 2, CommandWindowPosition: system.MaxWindowPosition}
+	first := pid.Position(state, now)
+	second := pid.Position(state, now.Add(time.Second))
+	if first != system.MaxWindowPosition {
+		t.Errorf("al rientro la posizione e' %d° invece di %d°", first, system.MaxWindowPosition)
+	}
+	if drop := first - second; float64(drop) > pid.MaxRate+1 {
+		t.Errorf("la finestra si chiude di %d° in un secondo", drop)
+	}
+}
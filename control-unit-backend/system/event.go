@@ -0,0 +1,111 @@
+package system
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventStatusChanged EventType = "status_changed"
+	EventAlarmRaised   EventType = "alarm_raised"
+	EventAlarmCleared  EventType = "alarm_cleared"
+	EventModeChanged   EventType = "mode_changed"
+	EventDeviceOnline  EventType = "device_online"
+	EventDeviceOffline EventType = "device_offline"
+	EventSensorFault   EventType = "sensor_fault"
+	EventSensorOK      EventType = "sensor_recovered"
+)
+
+// Evento generato dal system manager ad ogni transizione rilevante.
+type Event struct {
+	Zone        string
+	Type        EventType
+	Time        time.Time
+	Status      string
+	PrevStatus  string `json:",omitempty"`
+	Mode        string
+	Device      DeviceName `json:",omitempty"`
+	Fault       string     `json:",omitempty"`
+	Temperature float64
+}
+
+// Clone restituisce una copia dello stato che non condivide la mappa dei dispositivi,
+// da usare quando lo stato viene passato ad altre goroutine.
+func (s SystemState) Clone() SystemState {
+	s.DevicesOnline = maps.Clone(s.DevicesOnline)
+	s.DeviceStatus = maps.Clone(s.DeviceStatus)
+	s.Sensors = maps.Clone(s.Sensors)
+	s.ActiveSchedules = slices.Clone(s.ActiveSchedules)
+	return s
+}
+
+// RefreshDeviceStatus ricalcola lo stato dei dispositivi distinguendo il sensore in fault da quello offline.
+func (s *SystemState) RefreshDeviceStatus(sensorDevice DeviceName) {
+	if s.DeviceStatus == nil {
+		s.DeviceStatus = make(map[DeviceName]DeviceState, len(s.DevicesOnline))
+	}
+	for device, online := range s.DevicesOnline {
+		switch {
+		case !online:
+			s.DeviceStatus[device] = DeviceOffline
+		case device == sensorDevice && s.SensorFault != "":
+			s.DeviceStatus[device] = DeviceFault
+		default:
+			s.DeviceStatus[device] = DeviceOnline
+		}
+	}
+}
+
+// DiffEvents confronta due stati successivi e restituisce gli eventi corrispondenti.
+func DiffEvents(prev, curr SystemState, now time.Time) []Event {
+	var events []Event
+	newEvent := func(t EventType) Event {
+		return Event{
+			Zone:        curr.Zone,
+			Type:        t,
+			Time:        now,
+			Status:      curr.Status.String(),
+			Mode:        curr.OperativeMode.String(),
+			Temperature: curr.CurrentTemp,
+		}
+	}
+
+	if prev.Status != curr.Status {
+		e := newEvent(EventStatusChanged)
+		switch {
+		case curr.Status == Alarm:
+			e.Type = EventAlarmRaised
+		case prev.Status == Alarm:
+			e.Type = EventAlarmCleared
+		}
+		e.PrevStatus = prev.Status.String()
+		events = append(events, e)
+	}
+	if prev.OperativeMode != curr.OperativeMode {
+		events = append(events, newEvent(EventModeChanged))
+	}
+	if prev.SensorFault != curr.SensorFault {
+		e := newEvent(EventSensorFault)
+		e.Fault = curr.SensorFault
+		if curr.SensorFault == "" {
+			e.Type = EventSensorOK
+			e.Fault = prev.SensorFault
+		}
+		events = append(events, e)
+	}
+	for device, online := range curr.DevicesOnline {
+		if prev.DevicesOnline[device] == online {
+			continue
+		}
+		e := newEvent(EventDeviceOffline)
+		if online {
+			e.Type = EventDeviceOnline
+		}
+		e.Device = device
+		events = append(events, e)
+	}
+	return events
+}
@@ -0,0 +1,214 @@
+package system
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Strategia con cui le letture dei sensori di una stanza vengono combinate in un'unica temperatura.
+type FusionStrategy int
+
+const (
+	FuseMean FusionStrategy = iota
+	FuseMedian
+	FuseMax
+	FuseWeighted
+)
+
+func (f FusionStrategy) String() string {
+	switch f {
+	case FuseMean:
+		return "mean"
+	case FuseMedian:
+		return "median"
+	case FuseMax:
+		return "max"
+	case FuseWeighted:
+		return "weighted"
+	default:
+		return ""
+	}
+}
+
+func ParseFusionStrategy(s string) (FusionStrategy, error) {
+	for _, f := range []FusionStrategy{FuseMean, FuseMedian, FuseMax, FuseWeighted} {
+		if strings.EqualFold(s, f.String()) {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("strategia di fusione non valida: %q", s)
+}
+
+// Stato di un singolo sensore, esposto nelle API insieme al valore combinato.
+type SensorState struct {
+	Value    float64
+	LastSeen time.Time
+	Online   bool
+	Fault    string
+	Weight   float64
+	Health   SensorHealth
+
+	valid     bool
+	validator SensorValidator
+}
+
+type SensorSetConfig struct {
+	Zone     string // usata solo come etichetta delle metriche
+	Strategy FusionStrategy
+	Weights  map[string]float64 // peso per sensore, default 1
+	Timeout  time.Duration      // dopo questo tempo senza letture il sensore e' offline
+	MaxAge   time.Duration      // eta' massima di un campione con timestamp
+	// scarta i campioni vecchi o fuori ordine invece di contarli soltanto
+	RejectStale bool
+	Validation  ValidationConfig
+}
+
+// SensorSet tiene lo stato di tutti i sensori di una stanza; non e' thread-safe
+// ed e' pensato per essere usato solo dalla goroutine del system manager.
+type SensorSet struct {
+	config  SensorSetConfig
+	sensors map[string]*SensorState
+}
+
+func NewSensorSet(config SensorSetConfig) *SensorSet {
+	return &SensorSet{
+		config:  config,
+		sensors: make(map[string]*SensorState),
+	}
+}
+
+func (s *SensorSet) sensor(id string) *SensorState {
+	sensor, ok := s.sensors[id]
+	if !ok {
+		weight, ok := s.config.Weights[id]
+		if !ok {
+			weight = 1
+		}
+		sensor = &SensorState{
+			Weight:    weight,
+			validator: SensorValidator{Config: s.config.Validation},
+		}
+		s.sensors[id] = sensor
+	}
+	return sensor
+}
+
+// Update registra una lettura. Restituisce false, con il motivo, se la lettura e' stata scartata
+// perche' duplicata, non plausibile o, con RejectStale, fuori ordine o vecchia.
+func (s *SensorSet) Update(r TemperatureReading) (bool, string) {
+	sensor := s.sensor(r.SensorID)
+	sensor.LastSeen = r.ReceivedAt
+	sensor.Online = true
+
+	before := sensor.Health
+	accepted := sensor.Health.Observe(r, s.config.MaxAge, s.config.RejectStale)
+	countSensorHealth(s.config.Zone, r.SensorID, before, sensor.Health)
+	if !accepted {
+		return false, "sequenza o timestamp non validi"
+	}
+	valid, reason := sensor.validator.Validate(r.Value, r.ReceivedAt)
+	sensor.Fault = sensor.validator.Fault
+	if !valid {
+		return false, reason
+	}
+	sensor.Value = r.Value
+	sensor.valid = true
+	return true, ""
+}
+
+// Expire porta offline i sensori che non inviano letture da piu' di Timeout.
+// Restituisce gli ID dei sensori appena andati offline.
+func (s *SensorSet) Expire(now time.Time) []string {
+	var expired []string
+	for id, sensor := range s.sensors {
+		if sensor.Online && now.Sub(sensor.LastSeen) > s.config.Timeout {
+			sensor.Online = false
+			expired = append(expired, id)
+		}
+	}
+	return expired
+}
+
+func (s *SensorSet) AnyOnline() bool {
+	for _, sensor := range s.sensors {
+		if sensor.Online {
+			return true
+		}
+	}
+	return false
+}
+
+// Fault restituisce il motivo del fault se tutti i sensori online sono in fault, altrimenti "".
+func (s *SensorSet) Fault() string {
+	fault := ""
+	for _, id := range slices.Sorted(maps.Keys(s.sensors)) {
+		sensor := s.sensors[id]
+		if !sensor.Online {
+			continue
+		}
+		if sensor.Fault == "" {
+			return ""
+		}
+		if fault == "" {
+			fault = sensor.Fault
+		}
+	}
+	return fault
+}
+
+// Fused combina le ultime letture dei sensori online e senza fault secondo la strategia configurata.
+func (s *SensorSet) Fused() (float64, bool) {
+	var values, weights []float64
+	for _, sensor := range s.sensors {
+		if sensor.Online && sensor.valid && sensor.Fault == "" {
+			values = append(values, sensor.Value)
+			weights = append(weights, sensor.Weight)
+		}
+	}
+	if len(values) == 0 {
+		return 0, false
+	}
+
+	switch s.config.Strategy {
+	case FuseMedian:
+		slices.Sort(values)
+		mid := len(values) / 2
+		if len(values)%2 == 0 {
+			return (values[mid-1] + values[mid]) / 2, true
+		}
+		return values[mid], true
+	case FuseMax:
+		return slices.Max(values), true
+	case FuseWeighted:
+		var sum, totalWeight float64
+		for i, v := range values {
+			sum += v * weights[i]
+			totalWeight += weights[i]
+		}
+		if totalWeight > 0 {
+			return sum / totalWeight, true
+		}
+		fallthrough
+	default:
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values)), true
+	}
+}
+
+func (s *SensorSet) FaultPolicy() FaultPolicy {
+	return s.config.Validation.Policy
+}
+
+func (s *SensorSet) Snapshot() map[string]SensorState {
+	snapshot := make(map[string]SensorState, len(s.sensors))
+	for id, sensor := range s.sensors {
+		snapshot[id] = *sensor
+	}
+	return snapshot
+}
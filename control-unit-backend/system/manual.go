@@ -0,0 +1,96 @@
+package system
+
+import "time"
+
+// Tolleranza entro cui la finestra si considera arrivata alla posizione richiesta:
+// in manuale Arduino muove la finestra a passi di 5 gradi.
+const manualPositionTolerance Degree = 3
+
+// Stato dei comandi manuali della finestra da inviare ad Arduino.
+// Arduino esegue un passo solo quando il comando cambia, quindi dopo ogni passo viene inviato NoCommand.
+type ManualControl struct {
+	Command   int
+	Target    Degree
+	HasTarget bool
+	lastSent  int
+}
+
+func (m *ManualControl) SetTarget(position Degree) {
+	m.Target = min(max(position, 0), 90)
+	m.HasTarget = true
+	m.Command = NoCommand
+}
+
+func (m *ManualControl) Clear() {
+	m.Command = NoCommand
+	m.HasTarget = false
+}
+
+// NextCommand restituisce il comando da inviare ad Arduino dato l'attuale angolo della finestra.
+func (m *ManualControl) NextCommand(windowPosition Degree) int {
+	cmd := NoCommand
+	switch {
+	case m.lastSent != NoCommand:
+		// passo gia' inviato, si torna a NoCommand per abilitare il prossimo
+	case m.Command != NoCommand:
+		cmd = m.Command
+		m.Command = NoCommand
+	case m.HasTarget:
+		switch {
+		case windowPosition < m.Target-manualPositionTolerance:
+			cmd = CmdOpenWindow
+		case windowPosition > m.Target+manualPositionTolerance:
+			cmd = CmdCloseWindow
+		default:
+			m.HasTarget = false
+		}
+	}
+	m.lastSent = cmd
+	return cmd
+}
+
+// Lease della modalita' manuale: ogni comando manuale la rinnova e alla scadenza
+// il system manager torna in automatico. Con Duration <= 0 la modalita' manuale non scade.
+type ManualLease struct {
+	Duration  time.Duration
+	expiresAt time.Time
+	scheduled bool
+}
+
+// Update rinnova o annulla la lease dopo un comando. La modalita' manuale impostata
+// dal calendario non scade: resta tale fino alla fine della voce, quando il system manager
+// torna in automatico (vedi Scheduled). Un comando dell'operatore la trasforma in una lease normale.
+func (l *ManualLease) Update(actualSystemState *SystemState, source CommandSource, now time.Time) {
+	manual := actualSystemState.OperativeMode == Manual
+	l.scheduled = manual && source == SourceScheduler
+	if !manual || l.scheduled || l.Duration <= 0 {
+		l.expiresAt = time.Time{}
+	} else {
+		l.expiresAt = now.Add(l.Duration)
+	}
+	l.Refresh(actualSystemState, now)
+}
+
+// Scheduled indica se la modalita' manuale in corso e' stata impostata dal calendario
+// e non e' stata poi rinnovata da un comando dell'operatore.
+func (l *ManualLease) Scheduled() bool {
+	return l.scheduled
+}
+
+// Restore ripristina una scadenza salvata prima di un riavvio.
+func (l *ManualLease) Restore(expiresAt time.Time) {
+	l.expiresAt = expiresAt
+}
+
+func (l *ManualLease) Expired(now time.Time) bool {
+	return !l.expiresAt.IsZero() && !now.Before(l.expiresAt)
+}
+
+// Refresh aggiorna nello stato la scadenza e il tempo rimanente, arrotondato al secondo.
+func (l *ManualLease) Refresh(actualSystemState *SystemState, now time.Time) {
+	actualSystemState.ManualLeaseExpiresAt = l.expiresAt
+	actualSystemState.ManualLeaseRemaining = 0
+	if !l.expiresAt.IsZero() {
+		actualSystemState.ManualLeaseRemaining = max(l.expiresAt.Sub(now), 0).Round(time.Second)
+	}
+}
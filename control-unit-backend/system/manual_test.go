@@ -0,0 +1,35 @@
+package system_test
+
+import (
+	"server/system"
+	"testing"
+	"time"
+)
+
+func TestManualLeaseFromSchedulerDoesNotExpire(t *testing.T) {
+	lease := system.ManualLease{Duration: 10 * time.Minute}
+	state := system.SystemState{OperativeMode: system.Manual}
+
+	lease.Update(&state, system.SourceScheduler, start)
+	if !lease.Scheduled() {
+		t.Fatal("modalita' manuale dal calendario non riconosciuta")
+	}
+	if lease.Expired(start.Add(time.Hour)) || !state.ManualLeaseExpiresAt.IsZero() {
+		t.Fatal("la modalita' manuale del calendario non deve scadere")
+	}
+
+	// l'operatore prende in carico la zona: da qui vale la lease normale
+	lease.Update(&state, system.SourceHTTP, start.Add(time.Minute))
+	if lease.Scheduled() {
+		t.Fatal("modalita' manuale ancora attribuita al calendario dopo un comando dell'operatore")
+	}
+	if !lease.Expired(start.Add(11 * time.Minute)) {
+		t.Fatal("lease dell'operatore non scaduta")
+	}
+
+	state.OperativeMode = system.Automatic
+	lease.Update(&state, system.SourceScheduler, start.Add(time.Hour))
+	if lease.Scheduled() {
+		t.Fatal("in automatico la lease non e' del calendario")
+	}
+}
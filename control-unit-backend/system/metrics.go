@@ -0,0 +1,69 @@
+package system
+
+import "server/metrics"
+
+var (
+	temperatureGauge = metrics.NewGaugeVec("control_unit_temperature_celsius",
+		"Temperatura corrente (fusione dei sensori).", "zone")
+	averageTemperatureGauge = metrics.NewGaugeVec("control_unit_temperature_average_celsius",
+		"Temperatura media sulle ultime letture.", "zone")
+	statusGauge = metrics.NewGaugeVec("control_unit_status",
+		"Stato della zona: 0 normal, 1 hot, 2 too hot, 3 alarm.", "zone")
+	modeGauge = metrics.NewGaugeVec("control_unit_operative_mode",
+		"Modalita' operativa: 0 manuale, 1 automatica.", "zone")
+	windowCommandGauge = metrics.NewGaugeVec("control_unit_window_command_degrees",
+		"Apertura della finestra comandata.", "zone")
+	windowPositionGauge = metrics.NewGaugeVec("control_unit_window_position_degrees",
+		"Apertura della finestra riportata da Arduino.", "zone")
+	deviceOnlineGauge = metrics.NewGaugeVec("control_unit_device_online",
+		"1 se il dispositivo e' online.", "zone", "device")
+	sensorSamplesCounter = metrics.NewCounterVec("control_unit_sensor_samples_total",
+		"Letture dei sensori per esito: received, accepted, lost, duplicate, out_of_order, stale.",
+		"zone", "sensor", "kind")
+	sensorRestartsCounter = metrics.NewCounterVec("control_unit_sensor_restarts_total",
+		"Riavvii dei sensori rilevati dal numero di sequenza o dal contatore degli avvii.", "zone", "sensor")
+	transitionsCounter = metrics.NewCounterVec("control_unit_transitions_total",
+		"Transizioni di stato, modalita' e dispositivi.", "zone", "type")
+
+	// DroppedEntries conta i valori scartati perche' il buffer di un canale era pieno.
+	DroppedEntries = metrics.NewCounterVec("control_unit_buffer_dropped_total",
+		"Valori scartati per buffer pieno.", "zone", "buffer")
+)
+
+// UpdateMetrics aggiorna le gauge della zona con lo stato corrente.
+func UpdateMetrics(s SystemState) {
+	temperatureGauge.With(s.Zone).Set(s.CurrentTemp)
+	averageTemperatureGauge.With(s.Zone).Set(s.AverageTemp)
+	statusGauge.With(s.Zone).Set(float64(s.Status))
+	modeGauge.With(s.Zone).Set(float64(s.OperativeMode))
+	windowCommandGauge.With(s.Zone).Set(float64(s.CommandWindowPosition))
+	windowPositionGauge.With(s.Zone).Set(float64(s.WindowPosition))
+	for device, online := range s.DevicesOnline {
+		deviceOnlineGauge.With(s.Zone, string(device)).SetBool(online)
+	}
+	for id, sensor := range s.Sensors {
+		deviceOnlineGauge.With(s.Zone, "sensor:"+id).SetBool(sensor.Online)
+	}
+}
+
+func CountEvent(e Event) {
+	transitionsCounter.With(e.Zone, string(e.Type)).Inc()
+}
+
+// countSensorHealth aggiunge ai contatori del sensore le variazioni delle sue statistiche.
+func countSensorHealth(zone, sensor string, before, after SensorHealth) {
+	add := func(kind string, before, after uint64) {
+		if after > before {
+			sensorSamplesCounter.With(zone, sensor, kind).Add(float64(after - before))
+		}
+	}
+	add("received", before.Received, after.Received)
+	add("accepted", before.Accepted, after.Accepted)
+	add("lost", before.LostSamples, after.LostSamples)
+	add("duplicate", before.Duplicates, after.Duplicates)
+	add("out_of_order", before.OutOfOrder, after.OutOfOrder)
+	add("stale", before.Stale, after.Stale)
+	if after.Restarts > before.Restarts {
+		sensorRestartsCounter.With(zone, sensor).Add(float64(after.Restarts - before.Restarts))
+	}
+}
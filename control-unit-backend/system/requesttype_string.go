@@ -12,11 +12,13 @@ func _() {
 	_ = x[OpenWindow-1]
 	_ = x[CloseWindow-2]
 	_ = x[ResetAlarm-3]
+	_ = x[SetWindowPosition-4]
+	_ = x[SetMode-5]
 }
 
-const _RequestType_name = "ToggleModeOpenWindowCloseWindowResetAlarm"
+const _RequestType_name = "ToggleModeOpenWindowCloseWindowResetAlarmSetWindowPositionSetMode"
 
-var _RequestType_index = [...]uint8{0, 10, 20, 31, 41}
+var _RequestType_index = [...]uint8{0, 10, 20, 31, 41, 58, 65}
 
 func (i RequestType) String() string {
 	if i < 0 || i >= RequestType(len(_RequestType_index)-1) {
@@ -0,0 +1,123 @@
+package system
+
+import (
+	"time"
+)
+
+// Lettura di temperatura ricevuta da un sensore, gia' normalizzata in gradi Celsius.
+// I campi opzionali sono valorizzati solo se presenti nel payload.
+type TemperatureReading struct {
+	SensorID   string
+	Value      float64
+	Unit       string
+	Timestamp  time.Time // zero se il sensore non invia il timestamp
+	Seq        uint64
+	HasSeq     bool
+	Boot       uint64 // contatore degli avvii del sensore, se inviato
+	HasBoot    bool
+	Battery    *float64
+	RSSI       *int
+	ReceivedAt time.Time
+}
+
+// Statistiche sulla qualita' del flusso di letture di un sensore.
+type SensorHealth struct {
+	Received      uint64
+	Accepted      uint64
+	SequenceGaps  uint64
+	LostSamples   uint64
+	Duplicates    uint64
+	OutOfOrder    uint64
+	Stale         uint64 // campioni con timestamp lontano piu' di maxAge dall'istante di ricezione
+	Restarts      uint64
+	LastSeq       uint64
+	LastBoot      uint64 `json:",omitempty"`
+	LastTimestamp time.Time
+	LastReceived  time.Time
+	Battery       *float64
+	RSSI          *int
+
+	hasBoot bool
+}
+
+// salto all'indietro del numero di sequenza oltre il quale il sensore si considera riavviato
+// invece che in ritardo: i messaggi MQTT possono arrivare fuori ordine, ma non di centinaia
+const seqRestartJump = 100
+
+// Observe aggiorna le statistiche con una nuova lettura e indica se la lettura va usata.
+// I duplicati vengono sempre scartati; i campioni fuori ordine e quelli con timestamp lontano piu'
+// di maxAge dalla ricezione vengono contati e scartati solo con rejectStale. Un sensore senza NTP
+// o con l'orologio sfasato continua cosi' a essere usato: le sue letture valgono all'istante di
+// ricezione e il timestamp non viene usato per l'ordinamento.
+// maxAge <= 0 disabilita il controllo sui campioni vecchi.
+// Il sensore si considera riavviato quando cambia il contatore degli avvii oppure quando il
+// numero di sequenza torna a 0 o indietro di piu' di seqRestartJump.
+func (h *SensorHealth) Observe(r TemperatureReading, maxAge time.Duration, rejectStale bool) bool {
+	h.Received++
+	h.LastReceived = r.ReceivedAt
+	if r.Battery != nil {
+		h.Battery = r.Battery
+	}
+	if r.RSSI != nil {
+		h.RSSI = r.RSSI
+	}
+
+	late := false // gia' contato come fuori ordine dal numero di sequenza
+	if h.Accepted > 0 && h.restarted(r) {
+		h.Restarts++
+		h.LastTimestamp = time.Time{}
+		// la nuova sequenza vale anche se la lettura viene poi scartata
+		h.LastSeq = r.Seq
+	} else if r.HasSeq && h.Accepted > 0 {
+		switch {
+		case r.Seq == h.LastSeq:
+			h.Duplicates++
+			return false
+		case r.Seq < h.LastSeq:
+			h.OutOfOrder++
+			if rejectStale {
+				return false
+			}
+			late = true
+		case r.Seq > h.LastSeq+1:
+			h.SequenceGaps++
+			h.LostSamples += r.Seq - h.LastSeq - 1
+		}
+	}
+	if r.HasBoot {
+		h.LastBoot, h.hasBoot = r.Boot, true
+	}
+
+	if !r.Timestamp.IsZero() {
+		skew := r.ReceivedAt.Sub(r.Timestamp)
+		switch {
+		case maxAge > 0 && (skew > maxAge || skew < -maxAge):
+			h.Stale++
+			if rejectStale {
+				return false
+			}
+		case !h.LastTimestamp.IsZero() && r.Timestamp.Before(h.LastTimestamp):
+			if !late {
+				h.OutOfOrder++
+				if rejectStale {
+					return false
+				}
+			}
+		default:
+			h.LastTimestamp = r.Timestamp
+		}
+	}
+
+	if r.HasSeq && !late {
+		h.LastSeq = r.Seq
+	}
+	h.Accepted++
+	return true
+}
+
+func (h *SensorHealth) restarted(r TemperatureReading) bool {
+	if r.HasBoot && h.hasBoot {
+		return r.Boot != h.LastBoot
+	}
+	return r.HasSeq && r.Seq < h.LastSeq && (r.Seq == 0 || h.LastSeq-r.Seq > seqRestartJump)
+}
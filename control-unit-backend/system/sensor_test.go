@@ -0,0 +1,124 @@
+package system_test
+
+import (
+	"server/metrics"
+	"server/system"
+	"strings"
+	"testing"
+	"time"
+)
+
+func reading(seq uint64, timestamp, received time.Time) system.TemperatureReading {
+	return system.TemperatureReading{SensorID: "esp32", Value: 24, Seq: seq, HasSeq: true, Timestamp: timestamp, ReceivedAt: received}
+}
+
+func TestObserveAcceptsSensorWithoutClock(t *testing.T) {
+	var h system.SensorHealth
+	// ESP32 senza NTP: l'orologio riparte dal 1970 ad ogni accensione
+	epoch := time.Unix(0, 0)
+	for i := range 5 {
+		now := start.Add(time.Duration(i) * time.Second)
+		if !h.Observe(reading(uint64(i+1), epoch.Add(time.Duration(i)*time.Second), now), 5*time.Second, false) {
+			t.Fatalf("lettura %d scartata", i+1)
+		}
+	}
+	if h.Accepted != 5 || h.Stale != 5 || h.OutOfOrder != 0 {
+		t.Fatalf("statistiche %+v", h)
+	}
+}
+
+func TestObserveCountsOutOfOrderSamples(t *testing.T) {
+	var h system.SensorHealth
+	h.Observe(reading(1, start, start), 5*time.Second, false)
+	h.Observe(reading(3, start.Add(2*time.Second), start.Add(2*time.Second)), 5*time.Second, false)
+	// il campione 2 arriva in ritardo: viene usato ma non fa tornare indietro la sequenza
+	if !h.Observe(reading(2, start.Add(time.Second), start.Add(3*time.Second)), 5*time.Second, false) {
+		t.Fatal("campione fuori ordine scartato")
+	}
+	if h.OutOfOrder != 1 || h.LastSeq != 3 || !h.LastTimestamp.Equal(start.Add(2*time.Second)) {
+		t.Fatalf("statistiche %+v", h)
+	}
+	if h.Observe(reading(3, start.Add(2*time.Second), start.Add(4*time.Second)), 5*time.Second, false) {
+		t.Fatal("duplicato accettato")
+	}
+	if !h.Observe(reading(4, start.Add(4*time.Second), start.Add(4*time.Second)), 5*time.Second, false) || h.SequenceGaps != 1 {
+		t.Fatalf("il campione successivo e' stato contato come salto: %+v", h)
+	}
+}
+
+func TestObserveRejectsStaleSamplesWhenRequested(t *testing.T) {
+	var h system.SensorHealth
+	h.Observe(reading(1, start, start), 5*time.Second, true)
+	if h.Observe(reading(2, start.Add(-time.Minute), start.Add(time.Second)), 5*time.Second, true) {
+		t.Fatal("campione vecchio accettato")
+	}
+	if h.Observe(reading(1, start, start.Add(2*time.Second)), 5*time.Second, true) {
+		t.Fatal("duplicato accettato")
+	}
+	if h.Stale != 1 || h.Duplicates != 1 || h.Accepted != 1 {
+		t.Fatalf("statistiche %+v", h)
+	}
+}
+
+func TestObserveDetectsRestartAtLowSequence(t *testing.T) {
+	var h system.SensorHealth
+	now := start
+	for seq := uint64(500); seq <= 510; seq++ {
+		now = now.Add(time.Second)
+		h.Observe(reading(seq, time.Time{}, now), 0, false)
+	}
+	// il sensore riparte dalla sequenza 3 invece che da 0
+	for seq := uint64(3); seq <= 6; seq++ {
+		now = now.Add(time.Second)
+		if !h.Observe(reading(seq, time.Time{}, now), 0, true) {
+			t.Fatalf("lettura %d dopo il riavvio scartata", seq)
+		}
+	}
+	if h.Restarts != 1 || h.OutOfOrder != 0 || h.LastSeq != 6 {
+		t.Fatalf("statistiche %+v", h)
+	}
+}
+
+func TestObserveDetectsRestartFromBootCounter(t *testing.T) {
+	var h system.SensorHealth
+	booted := func(boot, seq uint64, received time.Time) system.TemperatureReading {
+		r := reading(seq, time.Time{}, received)
+		r.Boot, r.HasBoot = boot, true
+		return r
+	}
+	h.Observe(booted(1, 40, start), 0, false)
+	h.Observe(booted(1, 41, start.Add(time.Second)), 0, false)
+	// dopo il riavvio la sequenza riparte da un valore vicino: solo il contatore lo rivela
+	if !h.Observe(booted(2, 38, start.Add(2*time.Second)), 0, true) {
+		t.Fatal("prima lettura dopo il riavvio scartata")
+	}
+	if !h.Observe(booted(2, 39, start.Add(3*time.Second)), 0, true) {
+		t.Fatal("seconda lettura dopo il riavvio scartata")
+	}
+	if h.Restarts != 1 || h.OutOfOrder != 0 || h.LastBoot != 2 || h.LastSeq != 39 {
+		t.Fatalf("statistiche %+v", h)
+	}
+}
+
+func TestSensorHealthExportedAsMetrics(t *testing.T) {
+	sensors := system.NewSensorSet(system.SensorSetConfig{Zone: "metriche", Timeout: time.Minute})
+	r := reading(1, time.Time{}, start)
+	r.SensorID = "nord"
+	sensors.Update(r)
+	r.Seq, r.ReceivedAt = 4, start.Add(time.Second)
+	sensors.Update(r)
+	sensors.Update(r)
+
+	var out strings.Builder
+	metrics.Default.WriteTo(&out)
+	for _, line := range []string{
+		`control_unit_sensor_samples_total{zone="metriche",sensor="nord",kind="received"} 3`,
+		`control_unit_sensor_samples_total{zone="metriche",sensor="nord",kind="accepted"} 2`,
+		`control_unit_sensor_samples_total{zone="metriche",sensor="nord",kind="lost"} 2`,
+		`control_unit_sensor_samples_total{zone="metriche",sensor="nord",kind="duplicate"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("metrica mancante: %s", line)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package system
 
 import (
-	"log"
+	"server/logging"
 	"time"
 )
 
+var logger = logging.For("system")
+
 type DeviceName string
 type Degree int
 
@@ -43,10 +45,11 @@ func (ss SystemStatus) String() string { // cosi si aggiungono a mano
 
 // System rimane invariato.
 type SystemState struct {
+	Zone                  string
 	CurrentTemp           float64
 	AverageTemp           float64
-	MaxTemp               float64
-	MinTemp               float64
+	MaxTemp               *float64 // nil finche' non arriva la prima lettura
+	MinTemp               *float64
 	Status                SystemStatus
 	StatusString          string
 	SamplingInterval      time.Duration
@@ -55,6 +58,17 @@ type SystemState struct {
 	CommandWindowPosition Degree
 	OperativeMode         OperativeMode // "AUTOMATIC" o "MANUAL"
 	OperativeModeString   string
+	Sensors               map[string]SensorState // CurrentTemp e' la fusione di questi valori
+	SensorFault           string                 // vuoto se almeno un sensore fornisce valori plausibili
+	FailSafe              bool                   // finestra aperta per il fault dei sensori, fino al loro ritorno
+	DeviceStatus          map[DeviceName]DeviceState
+	Threshold1            float64 // soglie in uso, eventualmente da un profilo del calendario
+	Threshold2            float64
+	ForceWindowClosed     bool          // imposto da un profilo del calendario
+	ActiveSchedules       []string      // ID delle voci del calendario attive
+	ManualLeaseExpiresAt  time.Time     `json:",omitzero"` // scadenza della modalita' manuale
+	ManualLeaseRemaining  time.Duration // tempo rimanente prima del ritorno in automatico
+	Actuator              ActuatorHealth
 }
 
 //
@@ -66,6 +80,8 @@ const (
 	OpenWindow
 	CloseWindow
 	ResetAlarm
+	SetWindowPosition
+	SetMode
 )
 
 const (
@@ -83,7 +99,7 @@ func ToggleActualMode(actualSystemState *SystemState) {
 		actualSystemState.OperativeMode = Manual
 	}
 	actualSystemState.OperativeModeString = actualSystemState.OperativeMode.String()
-	log.Println("Modalita attuale: " + actualSystemState.OperativeModeString)
+	logger.Info("operative mode toggled", "zone", actualSystemState.Zone, "mode", actualSystemState.OperativeModeString)
 }
 
 func ManageTemperature(temp float64, tempHistory []float64, actualSystemState *SystemState) []float64 {
@@ -94,11 +110,11 @@ func ManageTemperature(temp float64, tempHistory []float64, actualSystemState *S
 	var sum float64
 	for _, t := range tempHistory {
 		sum += t
-		if t < actualSystemState.MinTemp {
-			actualSystemState.MinTemp = t
+		if actualSystemState.MinTemp == nil || t < *actualSystemState.MinTemp {
+			actualSystemState.MinTemp = &t
 		}
-		if t > actualSystemState.MaxTemp {
-			actualSystemState.MaxTemp = t
+		if actualSystemState.MaxTemp == nil || t > *actualSystemState.MaxTemp {
+			actualSystemState.MaxTemp = &t
 		}
 	}
 	actualSystemState.CurrentTemp = temp
@@ -106,30 +122,38 @@ func ManageTemperature(temp float64, tempHistory []float64, actualSystemState *S
 	return tempHistory
 }
 
-func manageMotorPosition(actualSystemState *SystemState, threshold1, threshold2 float64) {
-	switch actualSystemState.Status {
-	case Alarm, Too_hot:
-		actualSystemState.CommandWindowPosition = 90
-	case Hot:
-		actualSystemState.CommandWindowPosition = Degree(
-			(actualSystemState.CurrentTemp - threshold1) * (threshold2 / (threshold2 - threshold1)),
-		)
+// manageMotorPosition calcola la posizione comandata della finestra. Quando il controllo
+// non passa dalla strategia questa viene azzerata, cosi' che al rientro non usi uno stato
+// accumulato prima della pausa.
+func manageMotorPosition(actualSystemState *SystemState, strategy WindowStrategy, now time.Time) {
+	switch {
+	case actualSystemState.Status == Alarm || actualSystemState.Status == Too_hot || actualSystemState.FailSafe:
+		actualSystemState.CommandWindowPosition = MaxWindowPosition
+	case actualSystemState.ForceWindowClosed:
+		actualSystemState.CommandWindowPosition = MinWindowPosition
+	case actualSystemState.OperativeMode == Manual:
+		// la finestra e' mossa dall'operatore
 	default:
-		actualSystemState.CommandWindowPosition = 0
+		actualSystemState.CommandWindowPosition = strategy.Position(*actualSystemState, now)
+		return
 	}
+	strategy.Reset()
 }
 
+// ManageSystemLogic aggiorna stato e posizione della finestra in base alla temperatura.
+// Restituisce true se l'intervallo di campionamento e' cambiato e va comunicato al sensore.
 func ManageSystemLogic(
 	actualSystemState *SystemState,
 	threshold1, threshold2 float64,
 	normalFreq, fastFreq time.Duration,
-	intervalUpdatesChan chan<- time.Duration,
 	tooHotEnteredAt *time.Time,
-	tooHotMaxDuration time.Duration) {
+	tooHotMaxDuration time.Duration,
+	strategy WindowStrategy,
+	now time.Time) bool {
 
 	oldStatus := actualSystemState.Status
 	oldFreq := actualSystemState.SamplingInterval
-	now := time.Now()
+	actualSystemState.Threshold1, actualSystemState.Threshold2 = threshold1, threshold2
 
 	if actualSystemState.Status != Alarm {
 		if actualSystemState.CurrentTemp <= threshold1 {
@@ -148,20 +172,21 @@ func ManageSystemLogic(
 			}
 			if !tooHotEnteredAt.IsZero() && now.Sub(*tooHotEnteredAt) > tooHotMaxDuration {
 				actualSystemState.Status = Alarm
-				log.Println("ALLARME: Temperatura troppo alta per troppo tempo! Stato -> Alarm")
+				logger.Error("temperature too high for too long, entering alarm", "zone", actualSystemState.Zone, "temperature", actualSystemState.CurrentTemp, "max_duration", tooHotMaxDuration)
 				*tooHotEnteredAt = time.Time{}
 			}
 		}
 	}
 
-	manageMotorPosition(actualSystemState, threshold1, threshold2)
+	manageMotorPosition(actualSystemState, strategy, now)
 	actualSystemState.StatusString = actualSystemState.Status.String()
 	actualSystemState.OperativeModeString = actualSystemState.OperativeMode.String()
 	if actualSystemState.Status != oldStatus {
-		log.Printf("ATTENZIONE: Cambio di stato -> %s (Temp: %.1f°C)", actualSystemState.Status.String(), actualSystemState.CurrentTemp)
+		logger.Warn("status changed", "zone", actualSystemState.Zone, "from", oldStatus, "to", actualSystemState.Status, "temperature", actualSystemState.CurrentTemp)
 	}
 	if actualSystemState.SamplingInterval != oldFreq {
-		log.Printf("INFO: Frequenza di campionamento cambiata a %v", actualSystemState.SamplingInterval)
-		intervalUpdatesChan <- actualSystemState.SamplingInterval
+		logger.Info("sampling interval changed", "zone", actualSystemState.Zone, "interval", actualSystemState.SamplingInterval)
+		return true
 	}
+	return false
 }
@@ -0,0 +1,154 @@
+package system
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Comportamento del sistema quando il sensore e' in fault.
+type FaultPolicy int
+
+const (
+	HoldLastValue FaultPolicy = iota // si continua con l'ultimo valore valido
+	FailSafeOpen                     // la finestra viene aperta completamente
+	RaiseAlarm                       // il sistema va in allarme
+)
+
+func (p FaultPolicy) String() string {
+	switch p {
+	case HoldLastValue:
+		return "hold"
+	case FailSafeOpen:
+		return "open"
+	case RaiseAlarm:
+		return "alarm"
+	default:
+		return ""
+	}
+}
+
+func ParseFaultPolicy(s string) (FaultPolicy, error) {
+	for _, p := range []FaultPolicy{HoldLastValue, FailSafeOpen, RaiseAlarm} {
+		if strings.EqualFold(s, p.String()) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("politica di fault del sensore non valida: %q", s)
+}
+
+type DeviceState string
+
+const (
+	DeviceOnline  DeviceState = "online"
+	DeviceOffline DeviceState = "offline"
+	DeviceFault   DeviceState = "fault"
+)
+
+const (
+	FaultInvalidValue = "invalid-value"
+	FaultOutOfRange   = "out-of-range"
+	FaultRateOfChange = "rate-of-change"
+	FaultStuck        = "stuck-value"
+)
+
+type ValidationConfig struct {
+	MinValid          float64
+	MaxValid          float64
+	MaxRatePerSecond  float64       // 0 disabilita il controllo
+	StuckDuration     time.Duration // 0 disabilita il controllo
+	StuckTolerance    float64
+	FaultClearSamples int // letture valide consecutive necessarie per uscire dal fault
+	Policy            FaultPolicy
+}
+
+// Valida le letture di un singolo sensore prima che entrino nella logica del sistema.
+type SensorValidator struct {
+	Config ValidationConfig
+	Fault  string
+
+	lastGood     float64
+	lastGoodAt   time.Time
+	stuckValue   float64
+	stuckSince   time.Time
+	goodStreak   int
+	hasLastGood  bool
+	hasStuckBase bool
+}
+
+// Validate indica se la lettura e' plausibile. In caso contrario restituisce il motivo
+// e il validatore entra in fault fino a FaultClearSamples letture valide consecutive.
+func (v *SensorValidator) Validate(value float64, at time.Time) (bool, string) {
+	reason := v.check(value, at)
+	if reason != "" {
+		v.Fault = reason
+		v.goodStreak = 0
+		return false, reason
+	}
+
+	v.lastGood = value
+	v.lastGoodAt = at
+	v.hasLastGood = true
+	if v.Fault != "" {
+		v.goodStreak++
+		if v.goodStreak >= max(v.Config.FaultClearSamples, 1) {
+			v.Fault = ""
+			v.goodStreak = 0
+		}
+	}
+	return true, ""
+}
+
+func (v *SensorValidator) check(value float64, at time.Time) string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return FaultInvalidValue
+	}
+	if value < v.Config.MinValid || value > v.Config.MaxValid {
+		return FaultOutOfRange
+	}
+
+	if v.Config.StuckDuration > 0 {
+		if !v.hasStuckBase || math.Abs(value-v.stuckValue) > v.Config.StuckTolerance {
+			v.stuckValue = value
+			v.stuckSince = at
+			v.hasStuckBase = true
+		} else if at.Sub(v.stuckSince) > v.Config.StuckDuration {
+			return FaultStuck
+		}
+	}
+
+	if v.Config.MaxRatePerSecond > 0 && v.hasLastGood {
+		elapsed := at.Sub(v.lastGoodAt).Seconds()
+		if elapsed > 0 && math.Abs(value-v.lastGood)/elapsed > v.Config.MaxRatePerSecond {
+			return FaultRateOfChange
+		}
+	}
+	return ""
+}
+
+// ApplySensorFaultPolicy porta il sistema nello stato sicuro previsto dalla politica di fault.
+// Con FailSafeOpen la finestra resta aperta, anche se la strategia chiederebbe altro,
+// finche' LeaveFailSafe non rileva che i sensori sono tornati affidabili.
+func ApplySensorFaultPolicy(actualSystemState *SystemState, policy FaultPolicy) {
+	switch policy {
+	case FailSafeOpen:
+		if !actualSystemState.FailSafe {
+			logger.Warn("sensor fault, window opened for safety", "zone", actualSystemState.Zone, "fault", actualSystemState.SensorFault)
+		}
+		actualSystemState.FailSafe = true
+		actualSystemState.CommandWindowPosition = MaxWindowPosition
+	case RaiseAlarm:
+		actualSystemState.Status = Alarm
+		actualSystemState.StatusString = actualSystemState.Status.String()
+	}
+}
+
+// LeaveFailSafe restituisce la finestra alla logica normale quando i sensori tornano a fornire
+// valori plausibili; va chiamata ogni volta che SensorFault viene aggiornato.
+func LeaveFailSafe(actualSystemState *SystemState) {
+	if actualSystemState.FailSafe && actualSystemState.SensorFault == "" {
+		actualSystemState.FailSafe = false
+		logger.Info("sensors recovered, leaving fail-safe", "zone", actualSystemState.Zone)
+	}
+}
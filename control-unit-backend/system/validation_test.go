@@ -0,0 +1,51 @@
+package system_test
+
+import (
+	"server/system"
+	"testing"
+	"time"
+)
+
+func TestFailSafeHoldsWindowOpenUntilSensorsRecover(t *testing.T) {
+	state := system.SystemState{
+		Zone:          "serra",
+		Status:        system.Normal,
+		OperativeMode: system.Automatic,
+		CurrentTemp:   setpoint - 3, // la strategia vorrebbe chiudere la finestra
+		SensorFault:   system.FaultStuck,
+	}
+	pid := newPID()
+	var tooHotEnteredAt time.Time
+	step := func(now time.Time) {
+		system.ManageSystemLogic(&state, 80, 90, time.Second, time.Second, &tooHotEnteredAt, time.Minute, pid, now)
+	}
+
+	system.ApplySensorFaultPolicy(&state, system.FailSafeOpen)
+	now := start
+	for range 10 {
+		now = now.Add(time.Second)
+		step(now)
+		if state.CommandWindowPosition != system.MaxWindowPosition {
+			t.Fatalf("in fail-safe la finestra e' comandata a %d°", state.CommandWindowPosition)
+		}
+	}
+	// un fault che si risolve solo in parte non fa uscire dal fail-safe
+	system.LeaveFailSafe(&state)
+	if !state.FailSafe {
+		t.Fatal("fail-safe abbandonato con i sensori ancora in fault")
+	}
+
+	state.SensorFault = ""
+	system.LeaveFailSafe(&state)
+	if state.FailSafe {
+		t.Fatal("fail-safe non abbandonato al ritorno dei sensori")
+	}
+	// la strategia riprende dalla finestra aperta e la richiude alla velocita' massima
+	for range 2 {
+		now = now.Add(time.Second)
+		step(now)
+	}
+	if state.CommandWindowPosition >= system.MaxWindowPosition || float64(system.MaxWindowPosition-state.CommandWindowPosition) > pid.MaxRate+1 {
+		t.Fatalf("al ritorno dei sensori la finestra e' comandata a %d°", state.CommandWindowPosition)
+	}
+}
@@ -0,0 +1,85 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"server/alarm"
+	"server/audit"
+	"server/auth"
+	"time"
+)
+
+// GET /api/alarms?zone=<id> elenca gli allarmi attivi e lo storico; POST /api/alarms/{id}/ack
+// e /api/alarms/{id}/shelve permettono all'operatore di riconoscerli o accantonarli.
+type alarmHandler struct {
+	alarms *alarm.Manager
+	audit  *audit.Log
+}
+
+type alarmList struct {
+	Active  []alarm.Alarm
+	History []alarm.Alarm
+}
+
+type alarmAction struct {
+	Comment  string
+	Duration string // solo per shelve, es. "1h"; "0s" annulla lo shelving
+}
+
+func (h alarmHandler) list(w http.ResponseWriter, r *http.Request) {
+	zone := r.URL.Query().Get("zone")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alarmList{
+		Active:  h.alarms.Active(zone),
+		History: h.alarms.History(zone),
+	})
+}
+
+func (h alarmHandler) acknowledge(w http.ResponseWriter, r *http.Request) {
+	var action alarmAction
+	if err := json.NewDecoder(r.Body).Decode(&action); err != nil {
+		http.Error(w, "Richiesta non valida", http.StatusBadRequest)
+		return
+	}
+	a, err := h.alarms.Acknowledge(r.PathValue("id"), userName(r), action.Comment)
+	auditZoneAction(h.audit, r, a.Zone, "alarm-ack", map[string]any{"id": r.PathValue("id"), "comment": action.Comment}, err)
+	writeAlarm(w, a, err)
+}
+
+func (h alarmHandler) shelve(w http.ResponseWriter, r *http.Request) {
+	var action alarmAction
+	if err := json.NewDecoder(r.Body).Decode(&action); err != nil {
+		http.Error(w, "Richiesta non valida", http.StatusBadRequest)
+		return
+	}
+	duration, err := time.ParseDuration(action.Duration)
+	if err != nil {
+		http.Error(w, alarm.ErrNoDuration.Error(), http.StatusBadRequest)
+		return
+	}
+	a, err := h.alarms.Shelve(r.PathValue("id"), userName(r), action.Comment, duration)
+	auditZoneAction(h.audit, r, a.Zone, "alarm-shelve", map[string]any{"id": r.PathValue("id"), "comment": action.Comment, "duration": duration.String()}, err)
+	writeAlarm(w, a, err)
+}
+
+func writeAlarm(w http.ResponseWriter, a alarm.Alarm, err error) {
+	switch {
+	case errors.Is(err, alarm.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, alarm.ErrNotActive):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+	}
+}
+
+func registerAlarmRoutes(rt *router, alarms *alarm.Manager) {
+	h := alarmHandler{alarms: alarms, audit: rt.audit}
+	rt.handle("GET /api/alarms", auth.Viewer, h.list)
+	rt.handle("POST /api/alarms/{id}/ack", auth.Operator, h.acknowledge)
+	rt.handle("POST /api/alarms/{id}/shelve", auth.Operator, h.shelve)
+}
@@ -2,51 +2,187 @@ package webserver
 
 import (
 	"context"
-	"log"
+	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
+	"server/alarm"
+	"server/audit"
+	"server/auth"
+	"server/bus"
+	"server/schedule"
+	"server/supervisor"
 	"server/system"
+	"slices"
+	"strings"
 )
 
 // --- Middleware ---
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+
+// corsMiddleware abilita le richieste cross-origin solo dalle origini configurate;
+// la dashboard servita da questo stesso server non ne ha bisogno.
+func corsMiddleware(allowedOrigins []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" && (slices.Contains(allowedOrigins, origin) || slices.Contains(allowedOrigins, "*")) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+				w.Header().Add("Vary", "Origin")
+			}
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// router registra le route applicando CORS e il controllo del ruolo minimo richiesto.
+type router struct {
+	mux     *http.ServeMux
+	cors    func(http.Handler) http.Handler
+	auth    *auth.Store
+	audit   *audit.Log
+	options map[string]bool
+}
+
+func (rt *router) handle(pattern string, role auth.Role, handler http.HandlerFunc) {
+	rt.mux.Handle(pattern, rt.cors(rt.auth.Require(role, handler)))
+	rt.preflight(pattern)
+}
+
+// public registra una route accessibile senza autenticazione.
+func (rt *router) public(pattern string, handler http.HandlerFunc) {
+	rt.mux.Handle(pattern, rt.cors(handler))
+	rt.preflight(pattern)
+}
+
+// le route con metodo non rispondono a OPTIONS: si registra il preflight sullo stesso percorso
+func (rt *router) preflight(pattern string) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok || method == "OPTIONS" || rt.options[path] {
+		return
+	}
+	rt.options[path] = true
+	rt.mux.Handle("OPTIONS "+path, rt.cors(http.NotFoundHandler()))
+}
+
+// Canali verso il system manager di una zona.
+type Zone struct {
+	ID            string
+	Name          string
+	Commands      *bus.Topic[system.CommandRequest]
+	StateRequests *bus.Topic[chan system.SystemState]
+}
+
+type Config struct {
+	Zones          []Zone
+	Schedules      *schedule.Store
+	Alarms         *alarm.Manager
+	Auth           *auth.Store // nil: autenticazione disabilitata
+	Audit          *audit.Log
+	AllowedOrigins []string
+	SecureCookies  bool
+	MQTTConnected  func() bool                // usata da /readyz; nil se il broker non e' richiesto
+	Services       func() []supervisor.Status // usata da /healthz
 }
 
-func ApiServer(ctx context.Context, useMock bool, commandChan chan<- system.RequestType, stateReqChan chan<- chan system.SystemState) {
-	apiController := NewController(useMock, commandChan, stateReqChan)
-	routes := map[string]http.HandlerFunc{
-		"/api/system-status": apiController.GetSystemStatus,
-		"/api/change-mode":   apiController.ChangeMode,
-		"/api/open-window":   apiController.OpenWindow,
-		"/api/close-window":  apiController.CloseWindow,
-		"/api/reset-alarm":   apiController.ResetAlarm,
+type zoneInfo struct {
+	ID      string
+	Name    string
+	Default bool
+}
+
+// newHandler registra tutte le route: le API di ogni zona sotto /api/zones/{id}/..., con le
+// route storiche /api/... che fanno riferimento alla prima zona, quella di default.
+func newHandler(cfg Config) http.Handler {
+	zones := cfg.Zones
+	controllers := make(map[string]APIController, len(zones))
+	infos := make([]zoneInfo, 0, len(zones))
+	for i, zone := range zones {
+		controllers[zone.ID] = NewController(zone.Commands, zone.StateRequests)
+		infos = append(infos, zoneInfo{ID: zone.ID, Name: zone.Name, Default: i == 0})
+	}
+	defaultController := controllers[zones[0].ID]
+
+	rt := &router{
+		mux:     http.NewServeMux(),
+		cors:    corsMiddleware(cfg.AllowedOrigins),
+		auth:    cfg.Auth,
+		audit:   cfg.Audit,
+		options: make(map[string]bool),
 	}
-	for path, handler := range routes {
-		http.Handle(path, corsMiddleware(http.HandlerFunc(handler)))
+
+	routes := []struct {
+		name   string
+		role   auth.Role
+		action func(APIController, http.ResponseWriter, *http.Request)
+	}{
+		{"system-status", auth.Viewer, APIController.GetSystemStatus},
+		{"change-mode", auth.Operator, APIController.ChangeMode},
+		{"open-window", auth.Operator, APIController.OpenWindow},
+		{"close-window", auth.Operator, APIController.CloseWindow},
+		{"reset-alarm", auth.Operator, APIController.ResetAlarm},
+	}
+	for _, route := range routes {
+		rt.handle("/api/"+route.name, route.role, func(w http.ResponseWriter, r *http.Request) {
+			route.action(defaultController, w, r)
+		})
+		rt.handle("/api/zones/{id}/"+route.name, route.role, func(w http.ResponseWriter, r *http.Request) {
+			controller, ok := controllers[r.PathValue("id")]
+			if !ok {
+				http.Error(w, "Zona non trovata", http.StatusNotFound)
+				return
+			}
+			route.action(controller, w, r)
+		})
 	}
+	rt.handle("/api/zones", auth.Viewer, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+	})
+
+	registerAuthRoutes(rt, cfg.SecureCookies)
+	registerScheduleRoutes(rt, cfg.Schedules, zones)
+	registerAlarmRoutes(rt, cfg.Alarms)
+	registerAuditRoutes(rt, cfg.Audit)
+	registerLogRoutes(rt)
+	registerMetricsRoutes(rt)
+	registerHealthRoutes(rt, zones, cfg.MQTTConnected, cfg.Services)
 
 	fileServer := http.FileServer(http.Dir("../dashboard-frontend"))
-	http.Handle("/", fileServer)
+	rt.mux.Handle("/", fileServer)
+	return instrument(rt.mux)
+}
 
-	server := &http.Server{Addr: ":8080"}
+// ApiServer espone le API delle zone. Restituisce nil dopo la cancellazione di ctx,
+// un errore se il server non puo' essere avviato.
+func ApiServer(ctx context.Context, cfg Config) error {
+	server := &http.Server{Addr: ":8080", Handler: newHandler(cfg)}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return fmt.Errorf("impossibile avviare il server API su %s: %w", server.Addr, err)
+	}
 
+	// la goroutine di shutdown termina anche se Serve fallisce, cosi' i riavvii non ne lasciano indietro
+	served := make(chan struct{})
+	defer close(served)
 	go func() {
-		<-ctx.Done()
-		log.Println("API server: Shutdown")
-		server.Shutdown(context.Background()) //passo un context locale per lo shutdonw
+		select {
+		case <-ctx.Done():
+			logger.Info("api server stopped")
+			server.Shutdown(context.Background()) //passo un context locale per lo shutdonw
+		case <-served:
+		}
 	}()
 
-	log.Println("INFO: API in ascolto su :8080")
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("ERRORE: Impossibile avviare il server API: %v", err)
+	logger.Info("api server listening", "addr", server.Addr)
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("server API su %s terminato: %w", server.Addr, err)
 	}
+	return nil
 }
@@ -0,0 +1,79 @@
+package webserver
+
+import (
+	"net/http"
+	"server/audit"
+	"server/auth"
+	"strconv"
+	"time"
+)
+
+// auditAction registra un'azione eseguita da un operatore tramite le API.
+func auditAction(l *audit.Log, r *http.Request, action string, params map[string]any, err error) {
+	auditZoneAction(l, r, "", action, params, err)
+}
+
+// auditZoneAction registra un'azione che riguarda una zona; zone vuota se non e' nota.
+func auditZoneAction(l *audit.Log, r *http.Request, zone, action string, params map[string]any, err error) {
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	l.Append(audit.Record{
+		Time:   time.Now(),
+		Zone:   zone,
+		Source: "http",
+		User:   userName(r),
+		Action: action,
+		Params: params,
+		Result: result,
+	})
+}
+
+// GET /api/audit?zone=&source=&user=&action=&from=&to=&limit= con from e to in RFC3339.
+func auditSearch(l *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+		q := audit.Query{
+			Zone:   values.Get("zone"),
+			Source: values.Get("source"),
+			User:   values.Get("user"),
+			Action: values.Get("action"),
+			Limit:  100,
+		}
+		var err error
+		if from := values.Get("from"); from != "" {
+			if q.From, err = time.Parse(time.RFC3339, from); err != nil {
+				http.Error(w, "Parametro from non valido", http.StatusBadRequest)
+				return
+			}
+		}
+		if to := values.Get("to"); to != "" {
+			if q.To, err = time.Parse(time.RFC3339, to); err != nil {
+				http.Error(w, "Parametro to non valido", http.StatusBadRequest)
+				return
+			}
+		}
+		if limit := values.Get("limit"); limit != "" {
+			if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit <= 0 {
+				http.Error(w, "Parametro limit non valido", http.StatusBadRequest)
+				return
+			}
+			q.Limit = min(q.Limit, 1000)
+		}
+
+		records, err := l.Search(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if records == nil {
+			records = []audit.Record{}
+		}
+		writeJSON(w, http.StatusOK, records)
+	}
+}
+
+func registerAuditRoutes(rt *router, l *audit.Log) {
+	rt.handle("GET /api/audit", auth.Operator, auditSearch(l))
+}
@@ -0,0 +1,182 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"server/audit"
+	"server/auth"
+)
+
+type authHandler struct {
+	store  *auth.Store
+	secure bool
+	audit  *audit.Log
+}
+
+type credentials struct {
+	Username string
+	Password string
+	Role     auth.Role
+}
+
+type principalInfo struct {
+	Name string
+	Role auth.Role
+}
+
+// userName restituisce il nome dell'utente autenticato, usato per attribuire le azioni.
+func userName(r *http.Request) string {
+	if principal, ok := auth.FromContext(r.Context()); ok {
+		return principal.Name
+	}
+	return ""
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, auth.ErrUserExists), errors.Is(err, auth.ErrLastAdmin):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func (h authHandler) login(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Richiesta non valida", http.StatusBadRequest)
+		return
+	}
+	sessionID, principal, err := h.store.Login(c.Username, c.Password)
+	auditAction(h.audit, r, "login", map[string]any{"user": c.Username}, err)
+	if err != nil {
+		logger.Warn("login failed", "user", c.Username, "remote", r.RemoteAddr)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	h.store.SetSessionCookie(w, sessionID, h.secure)
+	writeJSON(w, http.StatusOK, principalInfo{Name: principal.Name, Role: principal.Role})
+}
+
+func (h authHandler) logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(auth.SessionCookie); err == nil {
+		h.store.Logout(cookie.Value)
+	}
+	auth.ClearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h authHandler) me(w http.ResponseWriter, r *http.Request) {
+	principal, _ := auth.FromContext(r.Context())
+	writeJSON(w, http.StatusOK, principalInfo{Name: principal.Name, Role: principal.Role})
+}
+
+func (h authHandler) listUsers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.store.Users())
+}
+
+func (h authHandler) createUser(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Richiesta non valida", http.StatusBadRequest)
+		return
+	}
+	err := h.store.AddUser(c.Username, c.Password, c.Role)
+	auditAction(h.audit, r, "user-create", map[string]any{"user": c.Username, "role": c.Role.String()}, err)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, principalInfo{Name: c.Username, Role: c.Role})
+}
+
+func (h authHandler) updateUser(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Richiesta non valida", http.StatusBadRequest)
+		return
+	}
+	name := r.PathValue("name")
+	err := h.store.UpdateUser(name, c.Password, c.Role)
+	auditAction(h.audit, r, "user-update", map[string]any{"user": name, "role": c.Role.String(), "password_changed": c.Password != ""}, err)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, principalInfo{Name: name, Role: c.Role})
+}
+
+func (h authHandler) deleteUser(w http.ResponseWriter, r *http.Request) {
+	err := h.store.DeleteUser(r.PathValue("name"))
+	auditAction(h.audit, r, "user-delete", map[string]any{"user": r.PathValue("name")}, err)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h authHandler) listTokens(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.store.Tokens())
+}
+
+// il segreto del token viene mostrato solo nella risposta di creazione
+func (h authHandler) createToken(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name string
+		Role auth.Role
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Name == "" {
+		http.Error(w, "Indicare il nome del token", http.StatusBadRequest)
+		return
+	}
+	token, secret, err := h.store.CreateToken(request.Name, request.Role)
+	auditAction(h.audit, r, "token-create", map[string]any{"name": request.Name, "role": request.Role.String()}, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		auth.Token
+		Secret string
+	}{token, secret})
+}
+
+func (h authHandler) deleteToken(w http.ResponseWriter, r *http.Request) {
+	err := h.store.DeleteToken(r.PathValue("id"))
+	auditAction(h.audit, r, "token-delete", map[string]any{"id": r.PathValue("id")}, err)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// con l'autenticazione disabilitata le route di gestione utenti non vengono registrate
+func registerAuthRoutes(rt *router, secure bool) {
+	if rt.auth == nil {
+		return
+	}
+	h := authHandler{store: rt.auth, secure: secure, audit: rt.audit}
+	rt.public("POST /api/login", h.login)
+	rt.handle("POST /api/logout", auth.Viewer, h.logout)
+	rt.handle("GET /api/me", auth.Viewer, h.me)
+
+	rt.handle("GET /api/users", auth.Admin, h.listUsers)
+	rt.handle("POST /api/users", auth.Admin, h.createUser)
+	rt.handle("PUT /api/users/{name}", auth.Admin, h.updateUser)
+	rt.handle("DELETE /api/users/{name}", auth.Admin, h.deleteUser)
+
+	rt.handle("GET /api/tokens", auth.Admin, h.listTokens)
+	rt.handle("POST /api/tokens", auth.Admin, h.createToken)
+	rt.handle("DELETE /api/tokens/{id}", auth.Admin, h.deleteToken)
+}
@@ -1,12 +1,20 @@
 package webserver
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
+	"server/bus"
 	"server/system"
+	"time"
 )
 
+// attesa massima della risposta del system manager a una richiesta HTTP
+const requestTimeout = 5 * time.Second
+
+var errNoResponse = errors.New("il system manager non risponde")
+
 type APIController interface {
 	GetSystemStatus(w http.ResponseWriter, r *http.Request)
 	ChangeMode(w http.ResponseWriter, r *http.Request)
@@ -15,46 +23,84 @@ type APIController interface {
 	ResetAlarm(w http.ResponseWriter, r *http.Request)
 }
 
-func NewController(useMock bool, commandChan chan<- system.RequestType, stateReqChan chan<- chan system.SystemState) APIController {
-	if useMock {
-		fmt.Println("INFO: Utilizzo del controller MOCK.")
-		return &MockController{}
-	}
-	fmt.Println("INFO: Utilizzo del controller REALE.")
+func NewController(commands *bus.Topic[system.CommandRequest], stateRequests *bus.Topic[chan system.SystemState]) APIController {
 	return &AppController{
-		commandChan:  commandChan,
-		stateReqChan: stateReqChan,
+		commands:      commands,
+		stateRequests: stateRequests,
 	}
 }
 
 // --- Implementazione Reale
 
 type AppController struct {
-	commandChan  chan<- system.RequestType
-	stateReqChan chan<- chan system.SystemState
+	commands      *bus.Topic[system.CommandRequest]
+	stateRequests *bus.Topic[chan system.SystemState]
 }
 
-// crea un canale e lo invia tramite il canale di comunicazione, poi aspetto la risposta sul canale inviato, async/await
-func (c *AppController) getState() system.SystemState {
-	replyChan := make(chan system.SystemState)
-	c.stateReqChan <- replyChan
-	return <-replyChan
+// crea un canale e lo pubblica sul topic delle richieste, poi aspetto la risposta sul canale inviato, async/await.
+// Rinuncia se il client si disconnette o il manager non risponde entro requestTimeout; il canale
+// e' bufferizzato cosi' che il manager non resti bloccato su una risposta che nessuno legge.
+func (c *AppController) getState(ctx context.Context) (system.SystemState, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	replyChan := make(chan system.SystemState, 1)
+	if err := c.stateRequests.PublishContext(ctx, replyChan); err != nil {
+		return system.SystemState{}, errNoResponse
+	}
+	select {
+	case state := <-replyChan:
+		return state, nil
+	case <-ctx.Done():
+		return system.SystemState{}, errNoResponse
+	}
 }
 
 func (c *AppController) GetSystemStatus(w http.ResponseWriter, r *http.Request) {
-	actualSystemState := c.getState()
+	actualSystemState, err := c.getState(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(actualSystemState)
 }
 
-// --- Metodi di scrittura (modificati per usare commandChan) ---
+// invia il comando al system manager e ne attende l'esito; in caso di rifiuto risponde 409,
+// se il manager non risponde entro requestTimeout 503
+func (c *AppController) sendCommand(w http.ResponseWriter, r *http.Request, requestType system.RequestType) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	request := system.NewCommandRequest("", requestType, system.SourceHTTP)
+	request.User = userName(r)
+	if err := c.commands.PublishContext(ctx, request); err != nil {
+		http.Error(w, errNoResponse.Error(), http.StatusServiceUnavailable)
+		return false
+	}
+	select {
+	case err := <-request.Reply:
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return false
+		}
+	case <-ctx.Done():
+		// il comando potrebbe essere comunque eseguito in seguito
+		logger.Warn("command timed out", "command", requestType, "user", request.User)
+		http.Error(w, errNoResponse.Error(), http.StatusServiceUnavailable)
+		return false
+	}
+	logger.Debug("command sent", "command", requestType, "user", request.User)
+	return true
+}
+
+// --- Metodi di scrittura (modificati per usare il topic dei comandi) ---
 func (c *AppController) ChangeMode(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Metodo non consentito", http.StatusMethodNotAllowed)
 		return
 	}
-	c.commandChan <- system.ToggleMode
-	fmt.Println("INFO: Inviato comando di cambio modalità.")
+	if !c.sendCommand(w, r, system.ToggleMode) {
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -63,8 +109,9 @@ func (c *AppController) OpenWindow(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Metodo non consentito", http.StatusMethodNotAllowed)
 		return
 	}
-	c.commandChan <- system.OpenWindow
-	fmt.Println("INFO: Inviato comando di apertura finestra.")
+	if !c.sendCommand(w, r, system.OpenWindow) {
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -73,8 +120,9 @@ func (c *AppController) CloseWindow(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Metodo non consentito", http.StatusMethodNotAllowed)
 		return
 	}
-	c.commandChan <- system.CloseWindow
-	fmt.Println("INFO: Inviato comando di chiusura finestra.")
+	if !c.sendCommand(w, r, system.CloseWindow) {
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -83,54 +131,8 @@ func (c *AppController) ResetAlarm(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Metodo non consentito", http.StatusMethodNotAllowed)
 		return
 	}
-	c.commandChan <- system.ResetAlarm
-	fmt.Println("INFO: Inviato comando di reset allarme.")
-	w.WriteHeader(http.StatusOK)
-}
-
-// --- Implementazione Mock  ---
-
-type MockController struct{}
-
-func (c *MockController) GetSystemStatus(w http.ResponseWriter, r *http.Request) {
-	actualSystemState := system.SystemState{
-		Status:              system.Normal,
-		StatusString:        system.Normal.String(),
-		SamplingInterval:    100,
-		OperativeMode:       system.Automatic,
-		OperativeModeString: system.Automatic.String(),
-		CurrentTemp:         25,
-		AverageTemp:         32,
-		MinTemp:             47,
-		MaxTemp:             12,
-		DevicesOnline: map[system.DeviceName]bool{
-			"server":  true,
-			"esp32":   false,
-			"arduino": false,
-		},
+	if !c.sendCommand(w, r, system.ResetAlarm) {
+		return
 	}
-
-	fmt.Println("Richiesta stato sistema (MOCK)")
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(actualSystemState)
-}
-
-func (c *MockController) ChangeMode(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Richiesta cambio modalità (MOCK)")
-	w.Write([]byte("OK"))
-}
-
-func (c *MockController) OpenWindow(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Richiesta apertura finestra (MOCK)")
-	w.Write([]byte("OK"))
-}
-
-func (c *MockController) CloseWindow(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Richiesta chiusura finestra (MOCK)")
-	w.Write([]byte("OK"))
-}
-
-func (c *MockController) ResetAlarm(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Allarme resettato! (MOCK)")
-	w.Write([]byte("OK"))
+	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,155 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"server/supervisor"
+	"server/system"
+	"time"
+)
+
+// tempo concesso al system manager di una zona per rispondere alla sonda
+const probeTimeout = 2 * time.Second
+
+// Esito del controllo di un componente; Zone e' vuoto per i componenti comuni.
+type componentHealth struct {
+	Name   string
+	Zone   string `json:",omitempty"`
+	OK     bool
+	Detail string `json:",omitempty"`
+}
+
+type healthReport struct {
+	Status     string // "ok" o "fail"
+	Components []componentHealth
+}
+
+func writeHealth(w http.ResponseWriter, components []componentHealth) {
+	report := healthReport{Status: "ok", Components: components}
+	status := http.StatusOK
+	for _, c := range components {
+		if !c.OK {
+			report.Status, status = "fail", http.StatusServiceUnavailable
+			break
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, report)
+}
+
+// probeState chiede lo stato al system manager della zona entro timeout, rinunciando prima se
+// ctx viene cancellato (per esempio perche' il client ha chiuso la connessione). Il canale di
+// risposta e' bufferizzato cosi' che il manager non resti bloccato se la sonda rinuncia.
+func probeState(ctx context.Context, zone Zone, timeout time.Duration) (system.SystemState, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	noResponse := func() error {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return errors.New("richiesta annullata")
+		}
+		return fmt.Errorf("nessuna risposta entro %v", timeout)
+	}
+	reply := make(chan system.SystemState, 1)
+	if err := zone.StateRequests.PublishContext(ctx, reply); err != nil {
+		return system.SystemState{}, noResponse()
+	}
+	select {
+	case state := <-reply:
+		return state, nil
+	case <-ctx.Done():
+		return system.SystemState{}, noResponse()
+	}
+}
+
+type healthHandler struct {
+	zones         []Zone
+	mqttConnected func() bool
+	services      func() []supervisor.Status
+}
+
+// GET /healthz: il processo e' vivo, nessun servizio del supervisor continua a fallire
+// e il system manager di ogni zona risponde. Lo stato di ogni servizio e' riportato in
+// Detail; un servizio in attesa di dipendenze o di un singolo riavvio non rende il processo malato.
+func (h healthHandler) healthz(w http.ResponseWriter, r *http.Request) {
+	var components []componentHealth
+	if h.services != nil {
+		for _, st := range h.services() {
+			c := componentHealth{Name: st.Name, OK: !st.Failing(), Detail: string(st.State)}
+			if st.Restarts > 0 {
+				c.Detail += fmt.Sprintf(", %d riavvii", st.Restarts)
+			}
+			if st.LastError != "" {
+				c.Detail += ", ultimo errore: " + st.LastError
+			}
+			components = append(components, c)
+		}
+	}
+	for _, zone := range h.zones {
+		c := componentHealth{Name: "manager-loop", Zone: zone.ID, OK: true}
+		if _, err := probeState(r.Context(), zone, probeTimeout); err != nil {
+			c.OK, c.Detail = false, err.Error()
+		}
+		components = append(components, c)
+	}
+	writeHealth(w, components)
+}
+
+// GET /readyz: broker MQTT connesso, se richiesto, e, per ogni zona, Arduino collegato e
+// letture dei sensori recenti, secondo lo stato dei dispositivi tenuto dal system manager.
+func (h healthHandler) readyz(w http.ResponseWriter, r *http.Request) {
+	var components []componentHealth
+	if h.mqttConnected != nil {
+		mqtt := componentHealth{Name: "mqtt", OK: h.mqttConnected()}
+		if !mqtt.OK {
+			mqtt.Detail = "broker non connesso"
+		}
+		components = append(components, mqtt)
+	}
+
+	now := time.Now()
+	for _, zone := range h.zones {
+		state, err := probeState(r.Context(), zone, probeTimeout)
+		if err != nil {
+			components = append(components, componentHealth{Name: "manager-loop", Zone: zone.ID, Detail: err.Error()})
+			continue
+		}
+		arduino := componentHealth{Name: "arduino", Zone: zone.ID, OK: state.DevicesOnline["arduino"]}
+		if !arduino.OK {
+			arduino.Detail = "nessun dato dalla seriale"
+		}
+		components = append(components, arduino, sensorHealth(zone.ID, state, now))
+	}
+	writeHealth(w, components)
+}
+
+func sensorHealth(zoneID string, state system.SystemState, now time.Time) componentHealth {
+	c := componentHealth{Name: "sensors", Zone: zoneID}
+	var lastSeen time.Time
+	for _, sensor := range state.Sensors {
+		if sensor.LastSeen.After(lastSeen) {
+			lastSeen = sensor.LastSeen
+		}
+	}
+	switch state.DeviceStatus["esp32"] {
+	case system.DeviceOnline:
+		c.OK = true
+		c.Detail = fmt.Sprintf("ultima lettura %v fa", now.Sub(lastSeen).Round(time.Millisecond))
+	case system.DeviceFault:
+		c.Detail = "sensore in fault: " + state.SensorFault
+	default:
+		c.Detail = "nessuna lettura recente"
+		if !lastSeen.IsZero() {
+			c.Detail = fmt.Sprintf("ultima lettura %v fa", now.Sub(lastSeen).Round(time.Second))
+		}
+	}
+	return c
+}
+
+// le sonde sono pubbliche: l'orchestratore non dispone di credenziali
+func registerHealthRoutes(rt *router, zones []Zone, mqttConnected func() bool, services func() []supervisor.Status) {
+	h := healthHandler{zones: zones, mqttConnected: mqttConnected, services: services}
+	rt.public("GET /healthz", h.healthz)
+	rt.public("GET /readyz", h.readyz)
+}
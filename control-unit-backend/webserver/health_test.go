@@ -0,0 +1,42 @@
+package webserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"server/bus"
+	"server/system"
+	"testing"
+	"time"
+)
+
+// zona il cui system manager non risponde mai
+func stuckZone(id string) Zone {
+	requests := bus.NewTopic[chan system.SystemState]("state-requests:" + id)
+	requests.Subscribe("manager", bus.Block, 0)
+	return Zone{ID: id, StateRequests: requests}
+}
+
+func TestProbeStateStopsWhenClientGoesAway(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := probeState(ctx, stuckZone("serra"), time.Minute); err == nil {
+		t.Fatal("sonda riuscita senza risposta del manager")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("sonda terminata dopo %v invece che alla cancellazione della richiesta", elapsed)
+	}
+}
+
+func TestHealthzReportsStuckManager(t *testing.T) {
+	h := healthHandler{zones: []Zone{stuckZone("serra")}}
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	ctx, cancel := context.WithTimeout(req.Context(), 20*time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	h.healthz(rec, req.WithContext(ctx))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("stato %d con il system manager bloccato", rec.Code)
+	}
+}
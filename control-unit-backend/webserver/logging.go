@@ -0,0 +1,40 @@
+package webserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"server/auth"
+	"server/logging"
+)
+
+var logger = logging.For("webserver")
+
+type logLevel struct {
+	Level string
+}
+
+// Livello di log: GET /api/log-level lo restituisce, PUT /api/log-level {"Level": "debug"}
+// lo cambia senza riavviare il server.
+func registerLogRoutes(rt *router) {
+	rt.handle("GET /api/log-level", auth.Admin, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, logLevel{Level: logging.Level().String()})
+	})
+	rt.handle("PUT /api/log-level", auth.Admin, func(w http.ResponseWriter, r *http.Request) {
+		var body logLevel
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Richiesta non valida", http.StatusBadRequest)
+			return
+		}
+		previous := logging.Level()
+		err := logging.SetLevel(body.Level)
+		auditAction(rt.audit, r, "log-level", map[string]any{"level": body.Level}, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Log(r.Context(), slog.LevelWarn, "log level changed",
+			"from", previous, "to", logging.Level(), "user", userName(r))
+		writeJSON(w, http.StatusOK, logLevel{Level: logging.Level().String()})
+	})
+}
@@ -0,0 +1,57 @@
+package webserver
+
+import (
+	"net/http"
+	"server/auth"
+	"server/metrics"
+	"strconv"
+)
+
+var httpRequests = metrics.NewCounterVec("control_unit_http_requests_total",
+	"Richieste HTTP per route e codice di risposta.", "route", "code")
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// instrument conta le richieste per pattern della route, cosi' che i percorsi con
+// parametri ({id}) non generino una serie per ogni valore.
+func instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+		mux.ServeHTTP(recorder, r)
+		route := r.Pattern // impostato dal mux
+		if route == "" {
+			route = "unmatched"
+		}
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequests.With(route, strconv.Itoa(status)).Inc()
+	})
+}
+
+// le metriche contengono gli stessi dati di system-status: Prometheus si autentica con un token
+func registerMetricsRoutes(rt *router) {
+	rt.handle("GET /metrics", auth.Viewer, metrics.Handler().ServeHTTP)
+}
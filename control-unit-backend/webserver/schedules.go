@@ -0,0 +1,72 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"server/audit"
+	"server/auth"
+	"server/schedule"
+)
+
+// Gestione del calendario: GET /api/schedules elenca le voci, POST ne crea una
+// (o la sostituisce se l'ID esiste), PUT e DELETE /api/schedules/{id} modificano la voce indicata.
+type scheduleHandler struct {
+	store *schedule.Store
+	zones map[string]bool
+	audit *audit.Log
+}
+
+func (h scheduleHandler) list(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.store.List())
+}
+
+func (h scheduleHandler) put(w http.ResponseWriter, r *http.Request) {
+	var entry schedule.Entry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		http.Error(w, "Voce del calendario non valida", http.StatusBadRequest)
+		return
+	}
+	if id := r.PathValue("id"); id != "" {
+		entry.ID = id
+	}
+	if entry.Zone != "" && !h.zones[entry.Zone] {
+		http.Error(w, "Zona non trovata", http.StatusBadRequest)
+		return
+	}
+	entry, err := h.store.Put(entry)
+	auditAction(h.audit, r, "schedule-put", map[string]any{"entry": entry}, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+func (h scheduleHandler) delete(w http.ResponseWriter, r *http.Request) {
+	found, err := h.store.Delete(r.PathValue("id"))
+	if found || err != nil {
+		auditAction(h.audit, r, "schedule-delete", map[string]any{"id": r.PathValue("id")}, err)
+	}
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	case !found:
+		http.Error(w, "Voce del calendario non trovata", http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// il calendario fa parte della configurazione: solo gli amministratori possono modificarlo
+func registerScheduleRoutes(rt *router, store *schedule.Store, zones []Zone) {
+	h := scheduleHandler{store: store, zones: make(map[string]bool, len(zones)), audit: rt.audit}
+	for _, zone := range zones {
+		h.zones[zone.ID] = true
+	}
+	rt.handle("GET /api/schedules", auth.Viewer, h.list)
+	rt.handle("POST /api/schedules", auth.Admin, h.put)
+	rt.handle("PUT /api/schedules/{id}", auth.Admin, h.put)
+	rt.handle("DELETE /api/schedules/{id}", auth.Admin, h.delete)
+}
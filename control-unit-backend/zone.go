@@ -0,0 +1,127 @@
+package main
+
+import (
+	"server/arduinoserial"
+	"server/bus"
+	"server/config"
+	"server/mqtt"
+	"server/system"
+	"time"
+)
+
+// Ogni zona ha i propri topic sul bus, il proprio insieme di sensori e la propria goroutine systemManager.
+type zone struct {
+	config   config.Zone
+	topics   mqtt.ZoneTopics
+	sensors  *system.SensorSet
+	actuator *system.ActuatorSupervisor
+	strategy system.WindowStrategy
+	bus      zoneBus
+	inputs   managerInputs
+}
+
+// Topic del bus interno di una zona; i nomi sono prefissati dall'ID della zona.
+type zoneBus struct {
+	interval      *bus.Topic[time.Duration]
+	temperature   *bus.Topic[system.TemperatureReading]
+	commands      *bus.Topic[system.CommandRequest]
+	stateRequests *bus.Topic[chan system.SystemState]
+	fromArduino   *bus.Topic[arduinoserial.DataFromArduino]
+	toArduino     *bus.Topic[arduinoserial.DataToArduino]
+	state         *bus.Topic[system.SystemState]
+	events        *bus.Topic[system.Event]
+}
+
+func newZoneBus(zoneID string) zoneBus {
+	name := func(topic string) string { return zoneID + "/" + topic }
+	return zoneBus{
+		interval:      bus.NewTopic[time.Duration](name("interval")),
+		temperature:   bus.NewTopic[system.TemperatureReading](name("temperature")),
+		commands:      bus.NewTopic[system.CommandRequest](name("commands")),
+		stateRequests: bus.NewTopic[chan system.SystemState](name("state-requests")),
+		fromArduino:   bus.NewTopic[arduinoserial.DataFromArduino](name("from-arduino")),
+		toArduino:     bus.NewTopic[arduinoserial.DataToArduino](name("to-arduino")),
+		state:         bus.NewTopic[system.SystemState](name("state")),
+		events:        bus.NewTopic[system.Event](name("events")),
+	}
+}
+
+// Sottoscrizioni del system manager. Sono create con la zona, cosi' che restino valide
+// se il manager viene riavviato e che nessun valore pubblicato prima dell'avvio vada perso.
+type managerInputs struct {
+	temperature   *bus.Subscription[system.TemperatureReading]
+	commands      *bus.Subscription[system.CommandRequest]
+	stateRequests *bus.Subscription[chan system.SystemState]
+	fromArduino   *bus.Subscription[arduinoserial.DataFromArduino]
+}
+
+func subscribeManager(b zoneBus) managerInputs {
+	return managerInputs{
+		// le letture non devono bloccare la callback MQTT: in caso di ritardo si perdono le piu' vecchie
+		temperature: b.temperature.Subscribe("manager", bus.DropOldest, 32),
+		// chi invia un comando o chiede lo stato attende comunque la risposta
+		commands:      b.commands.Subscribe("manager", bus.Block, 8),
+		stateRequests: b.stateRequests.Subscribe("manager", bus.Block, 8),
+		fromArduino:   b.fromArduino.Subscribe("manager", bus.DropOldest, 20),
+	}
+}
+
+func newZone(zoneConfig config.Zone, isDefault bool, sensorConfig config.Sensor, actuatorConfig config.Actuator) (*zone, error) {
+	faultPolicy, err := system.ParseFaultPolicy(sensorConfig.FaultPolicy)
+	if err != nil {
+		return nil, err
+	}
+	fusion, err := system.ParseFusionStrategy(sensorConfig.Fusion)
+	if err != nil {
+		return nil, err
+	}
+	weights := sensorConfig.Weights
+	if zoneConfig.SensorWeights != nil {
+		weights = zoneConfig.SensorWeights
+	}
+
+	sensors := system.NewSensorSet(system.SensorSetConfig{
+		Zone:        zoneConfig.ID,
+		Strategy:    fusion,
+		Weights:     weights,
+		Timeout:     sensorConfig.Timeout.Std(),
+		MaxAge:      sensorConfig.MaxAge.Std(),
+		RejectStale: sensorConfig.RejectStale,
+		Validation: system.ValidationConfig{
+			MinValid:          sensorConfig.MinValid,
+			MaxValid:          sensorConfig.MaxValid,
+			MaxRatePerSecond:  sensorConfig.MaxRatePerSecond,
+			StuckDuration:     sensorConfig.StuckDuration.Std(),
+			StuckTolerance:    sensorConfig.StuckTolerance,
+			FaultClearSamples: sensorConfig.FaultClearSamples,
+			Policy:            faultPolicy,
+		},
+	})
+
+	strategy, err := system.NewWindowStrategy(system.StrategyConfig{
+		Name:     zoneConfig.Control.Strategy,
+		Setpoint: zoneConfig.Control.Setpoint,
+		Kp:       zoneConfig.Control.Kp,
+		Ki:       zoneConfig.Control.Ki,
+		Kd:       zoneConfig.Control.Kd,
+		MaxRate:  zoneConfig.Control.MaxRate,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	zoneBus := newZoneBus(zoneConfig.ID)
+	return &zone{
+		config:   zoneConfig,
+		strategy: strategy,
+		topics:   mqtt.TopicsForZone(zoneConfig.ID, zoneConfig.Name, isDefault),
+		sensors:  sensors,
+		actuator: system.NewActuatorSupervisor(system.ActuatorConfig{
+			Timeout:    actuatorConfig.Timeout.Std(),
+			Tolerance:  system.Degree(actuatorConfig.Tolerance),
+			MaxRetries: actuatorConfig.MaxRetries,
+		}),
+		bus:    zoneBus,
+		inputs: subscribeManager(zoneBus),
+	}, nil
+}
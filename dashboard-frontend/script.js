@@ -17,8 +17,9 @@ function createChartConfig(data) {
 function aggiornaDatiTemperatura(data, chart) {
     document.getElementById('temp-current').textContent = data.CurrentTemp.toFixed(1);
     document.getElementById('temp-avg').textContent = data.AverageTemp.toFixed(1);
-    document.getElementById('temp-max').textContent = data.MaxTemp.toFixed(1);
-    document.getElementById('temp-min').textContent = data.MinTemp.toFixed(1);
+    // minimo e massimo sono null finche' non arriva la prima lettura
+    document.getElementById('temp-max').textContent = data.MaxTemp != null ? data.MaxTemp.toFixed(1) : '--';
+    document.getElementById('temp-min').textContent = data.MinTemp != null ? data.MinTemp.toFixed(1) : '--';
 
     // Aggiorna il grafico
     const chartData = chart.data;
@@ -76,10 +77,37 @@ function aggiornaModalita(mode) {
     }
 }
 
+// Le API richiedono l'autenticazione: alla prima risposta 401 viene chiesto il login.
+let loginInCorso = false;
+let loginAnnullato = false;
+
+function login() {
+    if (loginInCorso || loginAnnullato) return;
+    loginInCorso = true;
+    const username = prompt("Utente:");
+    const password = username !== null ? prompt("Password:") : null;
+    if (username === null || password === null) {
+        loginAnnullato = true;
+        loginInCorso = false;
+        return;
+    }
+    fetch("http://localhost:8080/api/login", {
+            method: 'POST',
+            credentials: 'include',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ Username: username, Password: password })
+        })
+        .then(response => {
+            if (!response.ok) alert("Credenziali non valide");
+        })
+        .finally(() => { loginInCorso = false; });
+}
+
 function update(chart) {
  console.log("ciao")
-    fetch("http://localhost:8080/api/system-status")
+    fetch("http://localhost:8080/api/system-status", { credentials: 'include' })
         .then(response => {
+            if (response.status === 401) login();
             if (!response.ok) throw new Error('Network response was not ok');
             return response.json();
         })
@@ -107,9 +135,12 @@ function update(chart) {
 
 function sendPostRequest(url) {
     fetch(url, {
-            method: 'POST'
+            method: 'POST',
+            credentials: 'include'
         })
         .then(response => {
+            if (response.status === 401) login();
+            if (response.status === 403) alert("Permessi insufficienti per questa operazione");
             if (!response.ok) {
                 throw new Error(`Network response was not ok for ${url}`);
             }